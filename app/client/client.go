@@ -0,0 +1,222 @@
+package client
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"github.com/jorzel/myredis/app/protocol"
+)
+
+// DefaultUser is the user every client is authenticated as, until AUTH or
+// HELLO AUTH says otherwise.
+const DefaultUser = "default"
+
+var lastID atomic.Int64
+
+// Client is the state of a single connection: its buffered output, the
+// negotiated protocol and everything commands keep between calls.
+//
+// Replies written with WriteReply are buffered until Flush, while Write sends
+// raw bytes, such as the replication stream, right away after them. Replies
+// to the master link are dropped, so a replica never answers its master, and
+// so are replies to internal clients.
+type Client struct {
+	net.Conn
+	id     int64
+	writer *protocol.Writer
+	// master marks the link a replica receives the replication stream on.
+	master bool
+	// discard drops replies, which nobody reads.
+	discard bool
+
+	mu   sync.Mutex
+	name string
+	db   int
+	user string
+	// tx queues commands between MULTI and EXEC, nil outside a transaction.
+	tx []protocol.Command
+	// channels and patterns are the pub/sub subscriptions of the client.
+	channels map[string]struct{}
+	patterns map[string]struct{}
+}
+
+// New creates a client speaking RESP2 on conn.
+func New(conn net.Conn) *Client {
+	return &Client{
+		Conn:     conn,
+		id:       lastID.Add(1),
+		writer:   protocol.NewWriter(conn),
+		user:     DefaultUser,
+		channels: map[string]struct{}{},
+		patterns: map[string]struct{}{},
+	}
+}
+
+// NewMaster creates the client commands received over the master link are
+// run as. Replies to it are discarded.
+func NewMaster(conn net.Conn) *Client {
+	c := New(conn)
+	c.master = true
+	c.discard = true
+	return c
+}
+
+// NewInternal creates a client without a connection, which the server runs
+// commands as on its own, such as when replaying the AOF. Replies to it are
+// discarded.
+func NewInternal() *Client {
+	c := New(nil)
+	c.discard = true
+	return c
+}
+
+// ID returns the unique identifier of the client.
+func (c *Client) ID() int64 {
+	return c.id
+}
+
+// IsMaster reports whether the client is the link to the master.
+func (c *Client) IsMaster() bool {
+	return c.master
+}
+
+// Version returns the protocol version used to reply to the client.
+func (c *Client) Version() int {
+	return c.writer.Version()
+}
+
+// SetVersion switches the protocol version used to reply to the client.
+func (c *Client) SetVersion(version int) {
+	c.writer.SetVersion(version)
+}
+
+// Name returns the name set with CLIENT SETNAME, empty if there is none.
+func (c *Client) Name() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.name
+}
+
+// SetName sets the name of the client.
+func (c *Client) SetName(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.name = name
+}
+
+// DB returns the index of the selected database.
+func (c *Client) DB() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.db
+}
+
+// SetDB selects the database with index db.
+func (c *Client) SetDB(db int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.db = db
+}
+
+// User returns the user the client is authenticated as.
+func (c *Client) User() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.user
+}
+
+// SetUser authenticates the client as user.
+func (c *Client) SetUser(user string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.user = user
+}
+
+// InTx reports whether the client is inside a MULTI transaction.
+func (c *Client) InTx() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.tx != nil
+}
+
+// BeginTx starts queueing commands for a transaction.
+func (c *Client) BeginTx() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tx = []protocol.Command{}
+}
+
+// QueueTx adds command to the current transaction.
+func (c *Client) QueueTx(command protocol.Command) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tx = append(c.tx, command)
+}
+
+// TxLen returns the number of commands queued in the current transaction.
+func (c *Client) TxLen() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.tx)
+}
+
+// EndTx leaves the transaction and returns the commands queued in it.
+func (c *Client) EndTx() []protocol.Command {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	queued := c.tx
+	c.tx = nil
+	return queued
+}
+
+// Subscribe subscribes the client to channel, or to a glob-style channel
+// pattern, and returns the number of its subscriptions.
+func (c *Client) Subscribe(channel string, pattern bool) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if pattern {
+		c.patterns[channel] = struct{}{}
+	} else {
+		c.channels[channel] = struct{}{}
+	}
+	return len(c.channels) + len(c.patterns)
+}
+
+// Unsubscribe removes a subscription and returns the number of the remaining ones.
+func (c *Client) Unsubscribe(channel string, pattern bool) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if pattern {
+		delete(c.patterns, channel)
+	} else {
+		delete(c.channels, channel)
+	}
+	return len(c.channels) + len(c.patterns)
+}
+
+// Subscriptions returns the number of channels and patterns the client is
+// subscribed to.
+func (c *Client) Subscriptions() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.channels) + len(c.patterns)
+}
+
+// WriteReply buffers reply until the client is flushed.
+func (c *Client) WriteReply(reply protocol.Reply) error {
+	if c.discard {
+		return nil
+	}
+	return c.writer.WriteReply(reply)
+}
+
+// Write sends b after any buffered replies.
+func (c *Client) Write(b []byte) (int, error) {
+	return c.writer.Write(b)
+}
+
+// Flush sends buffered replies.
+func (c *Client) Flush() error {
+	return c.writer.Flush()
+}
@@ -0,0 +1,63 @@
+package client
+
+import (
+	"net"
+	"testing"
+
+	"github.com/jorzel/myredis/app/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientBuffersRepliesUntilFlush(t *testing.T) {
+	server, peer := net.Pipe()
+	defer peer.Close()
+	c := New(server)
+	defer c.Close()
+
+	require.NoError(t, c.WriteReply(protocol.OK))
+	require.NoError(t, c.WriteReply(protocol.IntegerReply(1)))
+	go c.Flush()
+
+	buf := make([]byte, 64)
+	n, err := peer.Read(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "+OK\r\n:1\r\n", string(buf[:n]))
+}
+
+func TestMasterClientDropsReplies(t *testing.T) {
+	server, peer := net.Pipe()
+	defer peer.Close()
+	c := NewMaster(server)
+	defer c.Close()
+
+	require.NoError(t, c.WriteReply(protocol.OK))
+	go c.Write([]byte("raw"))
+
+	buf := make([]byte, 64)
+	n, err := peer.Read(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "raw", string(buf[:n]))
+	assert.True(t, c.IsMaster())
+}
+
+func TestClientState(t *testing.T) {
+	a, b := New(nil), New(nil)
+
+	assert.NotEqual(t, a.ID(), b.ID())
+	assert.Equal(t, protocol.RESP2, a.Version())
+	assert.Equal(t, DefaultUser, a.User())
+	assert.False(t, a.InTx())
+
+	a.BeginTx()
+	a.QueueTx(protocol.NewCommand("SET", []string{"k", "v"}))
+	assert.True(t, a.InTx())
+	assert.Equal(t, 1, a.TxLen())
+	assert.Len(t, a.EndTx(), 1)
+	assert.False(t, a.InTx())
+
+	assert.Equal(t, 1, a.Subscribe("news", false))
+	assert.Equal(t, 2, a.Subscribe("n*", true))
+	assert.Equal(t, 1, a.Unsubscribe("news", false))
+	assert.Equal(t, 1, a.Subscriptions())
+}
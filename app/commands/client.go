@@ -0,0 +1,146 @@
+package commands
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/jorzel/myredis/app/client"
+	"github.com/jorzel/myredis/app/protocol"
+)
+
+var errInvalidClientName = protocol.NewError("Client names cannot contain spaces, newlines or special characters.")
+
+// validClientName reports whether name only consists of printable characters
+// other than space, as Redis requires.
+func validClientName(name string) bool {
+	for i := 0; i < len(name); i++ {
+		if name[i] < '!' || name[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func (h *DefaultCommandHandler) handleClient(
+	ctx context.Context, c *client.Client, command protocol.Command,
+) (HandleResult, error) {
+	msg, commandErr := h.executeClient(ctx, c, command)
+	err := h.sendMsg(ctx, c, msg)
+	return HandleResult{
+		CommandError: commandErr,
+	}, err
+}
+
+func (h *DefaultCommandHandler) executeClient(
+	_ context.Context, c *client.Client, command protocol.Command,
+) (protocol.Reply, error) {
+	subcommand, args := strings.ToUpper(command.Args[0]), command.Args[1:]
+	switch subcommand {
+	case "ID":
+		if len(args) == 0 {
+			return protocol.IntegerReply(c.ID()), nil
+		}
+	case "GETNAME":
+		if len(args) == 0 {
+			if c.Name() == "" {
+				return protocol.NullReply{}, nil
+			}
+			return protocol.BulkStringReply(c.Name()), nil
+		}
+	case "SETNAME":
+		if len(args) == 1 {
+			if !validClientName(args[0]) {
+				return errInvalidClientName, errInvalidClientName
+			}
+			c.SetName(args[0])
+			return protocol.OK, nil
+		}
+	case "INFO":
+		if len(args) == 0 {
+			return protocol.VerbatimReply{Format: "txt", Text: clientInfo(c) + "\n"}, nil
+		}
+	case "HELP":
+		return protocol.ArrayReply{
+			protocol.SimpleStringReply("CLIENT <subcommand> [<arg> [value] [opt] ...]. Subcommands are:"),
+			protocol.SimpleStringReply("GETNAME"),
+			protocol.SimpleStringReply("    Return the name of the current connection."),
+			protocol.SimpleStringReply("ID"),
+			protocol.SimpleStringReply("    Return the ID of the current connection."),
+			protocol.SimpleStringReply("INFO"),
+			protocol.SimpleStringReply("    Return information about the current client connection."),
+			protocol.SimpleStringReply("SETNAME <name>"),
+			protocol.SimpleStringReply("    Assign the name <name> to the current connection."),
+			protocol.SimpleStringReply("HELP"),
+			protocol.SimpleStringReply("    Print this help."),
+		}, nil
+	default:
+		err := protocol.ErrUnknownSubcommand(command.Name, command.Args[0])
+		return err, err
+	}
+	err := protocol.ErrWrongArity(command.Name + "|" + subcommand)
+	return err, err
+}
+
+// clientInfo describes c in the format of CLIENT INFO.
+func clientInfo(c *client.Client) string {
+	var addr, laddr string
+	if a := c.RemoteAddr(); a != nil {
+		addr = a.String()
+	}
+	if a := c.LocalAddr(); a != nil {
+		laddr = a.String()
+	}
+	flags := "N"
+	if c.IsMaster() {
+		flags = "M"
+	} else if c.InTx() {
+		flags = "x"
+	}
+	fields := []string{
+		"id=" + strconv.FormatInt(c.ID(), 10),
+		"addr=" + addr,
+		"laddr=" + laddr,
+		"name=" + c.Name(),
+		"db=" + strconv.Itoa(c.DB()),
+		"sub=" + strconv.Itoa(c.Subscriptions()),
+		"multi=" + strconv.Itoa(txLen(c)),
+		"flags=" + flags,
+		"user=" + c.User(),
+		"resp=" + strconv.Itoa(c.Version()),
+	}
+	return strings.Join(fields, " ")
+}
+
+func txLen(c *client.Client) int {
+	if !c.InTx() {
+		return -1
+	}
+	return c.TxLen()
+}
+
+func (h *DefaultCommandHandler) handleSelect(
+	ctx context.Context, c *client.Client, command protocol.Command,
+) (HandleResult, error) {
+	msg, commandErr := h.executeSelect(ctx, c, command)
+	err := h.sendMsg(ctx, c, msg)
+	return HandleResult{
+		CommandError: commandErr,
+	}, err
+}
+
+// executeSelect selects a database. The server keeps a single one.
+func (h *DefaultCommandHandler) executeSelect(
+	_ context.Context, c *client.Client, command protocol.Command,
+) (protocol.Reply, error) {
+	db, err := strconv.Atoi(command.Args[0])
+	if err != nil {
+		return protocol.ErrNotInteger, protocol.ErrNotInteger
+	}
+	if db != 0 {
+		err := protocol.NewError("DB index is out of range")
+		return err, err
+	}
+	c.SetDB(db)
+	return protocol.OK, nil
+}
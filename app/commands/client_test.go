@@ -0,0 +1,91 @@
+package commands
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/jorzel/myredis/app/config"
+	"github.com/jorzel/myredis/app/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleClient(t *testing.T) {
+	tests := []struct {
+		name          string
+		commands      []protocol.Command
+		expectedReply string
+		expectedName  string
+	}{
+		{
+			name:          "Get name without a name",
+			commands:      []protocol.Command{protocol.NewCommand("CLIENT", []string{"GETNAME"})},
+			expectedReply: "$-1\r\n",
+		},
+		{
+			name: "Set and get name",
+			commands: []protocol.Command{
+				protocol.NewCommand("CLIENT", []string{"SETNAME", "worker"}),
+				protocol.NewCommand("CLIENT", []string{"getname"}),
+			},
+			expectedReply: "+OK\r\n$6\r\nworker\r\n",
+			expectedName:  "worker",
+		},
+		{
+			name:          "Set name with a space",
+			commands:      []protocol.Command{protocol.NewCommand("CLIENT", []string{"SETNAME", "a b"})},
+			expectedReply: "-ERR Client names cannot contain spaces, newlines or special characters.\r\n",
+		},
+		{
+			name:          "Wrong number of arguments of a subcommand",
+			commands:      []protocol.Command{protocol.NewCommand("CLIENT", []string{"SETNAME"})},
+			expectedReply: "-ERR wrong number of arguments for 'client|setname' command\r\n",
+		},
+		{
+			name:          "Unknown subcommand",
+			commands:      []protocol.Command{protocol.NewCommand("CLIENT", []string{"NOPE"})},
+			expectedReply: "-ERR unknown subcommand 'NOPE'. Try CLIENT HELP.\r\n",
+		},
+		{
+			name:          "Select the only database",
+			commands:      []protocol.Command{protocol.NewCommand("SELECT", []string{"0"})},
+			expectedReply: "+OK\r\n",
+		},
+		{
+			name:          "Select another database",
+			commands:      []protocol.Command{protocol.NewCommand("SELECT", []string{"1"})},
+			expectedReply: "-ERR DB index is out of range\r\n",
+		},
+		{
+			name:          "Select a database that is not a number",
+			commands:      []protocol.Command{protocol.NewCommand("SELECT", []string{"x"})},
+			expectedReply: "-ERR value is not an integer or out of range\r\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := &MockConn{}
+			handler := NewCommandHandler(&config.Config{})
+			var replies string
+			for _, command := range tt.commands {
+				_, err := handle(handler, conn, command)
+				require.NoError(t, err)
+				replies += string(conn.writes[len(conn.writes)-1])
+			}
+
+			assert.Equal(t, tt.expectedReply, replies)
+			assert.Equal(t, tt.expectedName, conn.Client().Name())
+		})
+	}
+}
+
+func TestHandleClientID(t *testing.T) {
+	conn := &MockConn{}
+	handler := NewCommandHandler(&config.Config{})
+
+	_, err := handle(handler, conn, protocol.NewCommand("CLIENT", []string{"ID"}))
+
+	require.NoError(t, err)
+	require.Len(t, conn.writes, 1)
+	assert.Equal(t, ":"+strconv.FormatInt(conn.Client().ID(), 10)+"\r\n", string(conn.writes[0]))
+}
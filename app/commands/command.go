@@ -0,0 +1,240 @@
+package commands
+
+import (
+	"context"
+	"strings"
+
+	"github.com/jorzel/myredis/app/client"
+	"github.com/jorzel/myredis/app/protocol"
+)
+
+// groupCategories maps command groups to the ACL categories they belong to.
+var groupCategories = map[string]string{
+	"string":     "@string",
+	"generic":    "@keyspace",
+	"connection": "@connection",
+}
+
+// Categories returns the ACL categories of the command, derived from its
+// flags and group.
+func (s *Spec) Categories() []string {
+	categories := []string{}
+	if s.Has(FlagWrite) {
+		categories = append(categories, "@write")
+	}
+	if s.Has(FlagReadOnly) {
+		categories = append(categories, "@read")
+	}
+	if category, ok := groupCategories[s.Group]; ok {
+		categories = append(categories, category)
+	}
+	if s.Has(FlagPubSub) {
+		categories = append(categories, "@pubsub")
+	}
+	if s.Has(FlagAdmin) {
+		categories = append(categories, "@admin", "@dangerous")
+	}
+	if s.Has(FlagFast) {
+		categories = append(categories, "@fast")
+	} else {
+		categories = append(categories, "@slow")
+	}
+	return categories
+}
+
+func (h *DefaultCommandHandler) handleCommand(
+	ctx context.Context, c *client.Client, command protocol.Command,
+) (HandleResult, error) {
+	msg, commandErr := h.executeCommand(ctx, command)
+	err := h.sendMsg(ctx, c, msg)
+	return HandleResult{
+		CommandError: commandErr,
+	}, err
+}
+
+func (h *DefaultCommandHandler) executeCommand(_ context.Context, command protocol.Command) (protocol.Reply, error) {
+	if len(command.Args) == 0 {
+		return allCommandInfos(), nil
+	}
+
+	subcommand, args := strings.ToUpper(command.Args[0]), command.Args[1:]
+	switch subcommand {
+	case "COUNT":
+		if len(args) != 0 {
+			break
+		}
+		return protocol.IntegerReply(len(registry)), nil
+	case "LIST":
+		if len(args) != 0 {
+			break
+		}
+		names := []string{}
+		for _, spec := range Specs() {
+			names = append(names, spec.Name)
+		}
+		return protocol.BulkStrings(names...), nil
+	case "INFO":
+		if len(args) == 0 {
+			return allCommandInfos(), nil
+		}
+		infos := protocol.ArrayReply{}
+		for _, name := range args {
+			if spec, ok := Lookup(name); ok {
+				infos = append(infos, commandInfo(spec))
+			} else {
+				infos = append(infos, protocol.NullArrayReply{})
+			}
+		}
+		return infos, nil
+	case "DOCS":
+		specs := Specs()
+		if len(args) > 0 {
+			specs = specs[:0]
+			for _, name := range args {
+				if spec, ok := Lookup(name); ok {
+					specs = append(specs, spec)
+				}
+			}
+		}
+		docs := protocol.MapReply{}
+		for _, spec := range specs {
+			docs = append(docs, protocol.KeyValue{
+				Key:   protocol.BulkStringReply(spec.Name),
+				Value: commandDocs(spec),
+			})
+		}
+		return docs, nil
+	case "GETKEYS":
+		if len(args) == 0 {
+			break
+		}
+		return getKeys(protocol.NewCommand(args[0], args[1:]))
+	case "HELP":
+		return commandHelp(), nil
+	default:
+		err := protocol.ErrUnknownSubcommand(command.Name, command.Args[0])
+		return err, err
+	}
+	err := protocol.ErrWrongArity(command.Name + "|" + subcommand)
+	return err, err
+}
+
+func allCommandInfos() protocol.Reply {
+	infos := protocol.ArrayReply{}
+	for _, spec := range Specs() {
+		infos = append(infos, commandInfo(spec))
+	}
+	return infos
+}
+
+// commandInfo describes spec in the format of COMMAND INFO.
+func commandInfo(spec *Spec) protocol.Reply {
+	flags := protocol.SetReply{}
+	for _, name := range spec.Flags.Names() {
+		flags = append(flags, protocol.SimpleStringReply(name))
+	}
+	categories := protocol.SetReply{}
+	for _, category := range spec.Categories() {
+		categories = append(categories, protocol.SimpleStringReply(category))
+	}
+	keySpecs := protocol.ArrayReply{}
+	if spec.FirstKey > 0 {
+		keyFlags := protocol.SetReply{}
+		for _, flag := range spec.KeyFlags {
+			keyFlags = append(keyFlags, protocol.SimpleStringReply(flag))
+		}
+		// Range key specs count the last key relative to the first one.
+		lastKey := spec.LastKey
+		if lastKey > 0 {
+			lastKey -= spec.FirstKey
+		}
+		keySpecs = append(keySpecs, protocol.MapReply{
+			{Key: protocol.BulkStringReply("flags"), Value: keyFlags},
+			{Key: protocol.BulkStringReply("begin_search"), Value: protocol.MapReply{
+				{Key: protocol.BulkStringReply("type"), Value: protocol.BulkStringReply("index")},
+				{Key: protocol.BulkStringReply("spec"), Value: protocol.MapReply{
+					{Key: protocol.BulkStringReply("index"), Value: protocol.IntegerReply(spec.FirstKey)},
+				}},
+			}},
+			{Key: protocol.BulkStringReply("find_keys"), Value: protocol.MapReply{
+				{Key: protocol.BulkStringReply("type"), Value: protocol.BulkStringReply("range")},
+				{Key: protocol.BulkStringReply("spec"), Value: protocol.MapReply{
+					{Key: protocol.BulkStringReply("lastkey"), Value: protocol.IntegerReply(lastKey)},
+					{Key: protocol.BulkStringReply("keystep"), Value: protocol.IntegerReply(spec.KeyStep)},
+					{Key: protocol.BulkStringReply("limit"), Value: protocol.IntegerReply(0)},
+				}},
+			}},
+		})
+	}
+
+	return protocol.ArrayReply{
+		protocol.BulkStringReply(spec.Name),
+		protocol.IntegerReply(spec.Arity),
+		flags,
+		protocol.IntegerReply(spec.FirstKey),
+		protocol.IntegerReply(spec.LastKey),
+		protocol.IntegerReply(spec.KeyStep),
+		categories,
+		protocol.SetReply{}, // tips
+		keySpecs,
+		protocol.ArrayReply{}, // subcommands
+	}
+}
+
+// commandDocs documents spec in the format of COMMAND DOCS.
+func commandDocs(spec *Spec) protocol.Reply {
+	docs := protocol.MapReply{
+		{Key: protocol.BulkStringReply("summary"), Value: protocol.BulkStringReply(spec.Summary)},
+		{Key: protocol.BulkStringReply("since"), Value: protocol.BulkStringReply(spec.Since)},
+		{Key: protocol.BulkStringReply("group"), Value: protocol.BulkStringReply(spec.Group)},
+	}
+	if spec.Complexity != "" {
+		docs = append(docs, protocol.KeyValue{
+			Key:   protocol.BulkStringReply("complexity"),
+			Value: protocol.BulkStringReply(spec.Complexity),
+		})
+	}
+	return docs
+}
+
+// getKeys extracts the keys of command, so proxies can route it.
+func getKeys(command protocol.Command) (protocol.Reply, error) {
+	spec, ok := Lookup(command.Name)
+	if !ok {
+		err := protocol.NewError("Invalid command specified")
+		return err, err
+	}
+	if !spec.CheckArity(len(command.Args) + 1) {
+		err := protocol.NewError("Invalid number of arguments specified for command")
+		return err, err
+	}
+	keys := spec.Keys(command)
+	if len(keys) == 0 {
+		err := protocol.NewError("The command has no key arguments")
+		return err, err
+	}
+	return protocol.BulkStrings(keys...), nil
+}
+
+func commandHelp() protocol.Reply {
+	return protocol.ArrayReply{
+		protocol.SimpleStringReply("COMMAND <subcommand> [<arg> [value] [opt] ...]. Subcommands are:"),
+		protocol.SimpleStringReply("(no subcommand)"),
+		protocol.SimpleStringReply("    Return details about all commands."),
+		protocol.SimpleStringReply("COUNT"),
+		protocol.SimpleStringReply("    Return the total number of commands in this server."),
+		protocol.SimpleStringReply("LIST"),
+		protocol.SimpleStringReply("    Return a list of all commands in this server."),
+		protocol.SimpleStringReply("INFO [<command-name> ...]"),
+		protocol.SimpleStringReply("    Return details about multiple commands."),
+		protocol.SimpleStringReply("    If no command names are given, details for all commands are returned."),
+		protocol.SimpleStringReply("DOCS [<command-name> ...]"),
+		protocol.SimpleStringReply("    Return documentation details about multiple commands."),
+		protocol.SimpleStringReply("    If no command names are given, documentation details for all"),
+		protocol.SimpleStringReply("    commands are returned."),
+		protocol.SimpleStringReply("GETKEYS <full-command>"),
+		protocol.SimpleStringReply("    Return the keys from a full Redis command."),
+		protocol.SimpleStringReply("HELP"),
+		protocol.SimpleStringReply("    Print this help."),
+	}
+}
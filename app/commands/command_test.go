@@ -0,0 +1,99 @@
+package commands
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/jorzel/myredis/app/config"
+	"github.com/jorzel/myredis/app/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleCommand(t *testing.T) {
+	tests := []struct {
+		name          string
+		args          []string
+		expectedReply string
+	}{
+		{
+			name:          "Count",
+			args:          []string{"COUNT"},
+			expectedReply: ":" + strconv.Itoa(len(registry)) + "\r\n",
+		},
+		{
+			name: "Info of a read only command",
+			args: []string{"INFO", "get"},
+			expectedReply: "*1\r\n*10\r\n$3\r\nget\r\n:2\r\n*2\r\n+readonly\r\n+fast\r\n:1\r\n:1\r\n:1\r\n" +
+				"*3\r\n+@read\r\n+@string\r\n+@fast\r\n*0\r\n" +
+				"*1\r\n*6\r\n$5\r\nflags\r\n*2\r\n+RO\r\n+ACCESS\r\n" +
+				"$12\r\nbegin_search\r\n*4\r\n$4\r\ntype\r\n$5\r\nindex\r\n$4\r\nspec\r\n*2\r\n$5\r\nindex\r\n:1\r\n" +
+				"$9\r\nfind_keys\r\n*4\r\n$4\r\ntype\r\n$5\r\nrange\r\n$4\r\nspec\r\n" +
+				"*6\r\n$7\r\nlastkey\r\n:0\r\n$7\r\nkeystep\r\n:1\r\n$5\r\nlimit\r\n:0\r\n*0\r\n",
+		},
+		{
+			name:          "Info of an unknown command",
+			args:          []string{"INFO", "nope"},
+			expectedReply: "*1\r\n*-1\r\n",
+		},
+		{
+			name: "Docs",
+			args: []string{"DOCS", "echo"},
+			expectedReply: "*2\r\n$4\r\necho\r\n*8\r\n$7\r\nsummary\r\n$25\r\nReturns the given string.\r\n" +
+				"$5\r\nsince\r\n$5\r\n1.0.0\r\n$5\r\ngroup\r\n$10\r\nconnection\r\n$10\r\ncomplexity\r\n$4\r\nO(1)\r\n",
+		},
+		{
+			name:          "Get keys",
+			args:          []string{"GETKEYS", "DEL", "a", "b"},
+			expectedReply: "*2\r\n$1\r\na\r\n$1\r\nb\r\n",
+		},
+		{
+			name:          "Get keys of a command without keys",
+			args:          []string{"GETKEYS", "PING"},
+			expectedReply: "-ERR The command has no key arguments\r\n",
+		},
+		{
+			name:          "Get keys with a wrong number of arguments",
+			args:          []string{"GETKEYS", "GET"},
+			expectedReply: "-ERR Invalid number of arguments specified for command\r\n",
+		},
+		{
+			name:          "Get keys of an unknown command",
+			args:          []string{"GETKEYS", "NOPE", "a"},
+			expectedReply: "-ERR Invalid command specified\r\n",
+		},
+		{
+			name:          "Unknown subcommand",
+			args:          []string{"NOPE"},
+			expectedReply: "-ERR unknown subcommand 'NOPE'. Try COMMAND HELP.\r\n",
+		},
+		{
+			name:          "Wrong number of arguments of a subcommand",
+			args:          []string{"COUNT", "x"},
+			expectedReply: "-ERR wrong number of arguments for 'command|count' command\r\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := &MockConn{}
+			handler := NewCommandHandler(&config.Config{})
+			_, err := handle(handler, conn, protocol.NewCommand("COMMAND", tt.args))
+
+			require.NoError(t, err)
+			require.Len(t, conn.writes, 1)
+			assert.Equal(t, tt.expectedReply, string(conn.writes[0]))
+		})
+	}
+}
+
+func TestHandleCommandListsAllCommands(t *testing.T) {
+	conn := &MockConn{}
+	handler := NewCommandHandler(&config.Config{})
+	_, err := handle(handler, conn, protocol.NewCommand("COMMAND", nil))
+
+	require.NoError(t, err)
+	require.Len(t, conn.writes, 1)
+	first := Specs()[0].Name
+	assert.Contains(t, string(conn.writes[0]),
+		"*"+strconv.Itoa(len(registry))+"\r\n*10\r\n$"+strconv.Itoa(len(first))+"\r\n"+first+"\r\n")
+}
@@ -0,0 +1,122 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jorzel/myredis/app/client"
+	"github.com/jorzel/myredis/app/config"
+	"github.com/jorzel/myredis/app/protocol"
+	"github.com/rs/zerolog"
+)
+
+func (h *DefaultCommandHandler) handleConfig(
+	ctx context.Context, c *client.Client, command protocol.Command,
+) (HandleResult, error) {
+	msg, commandErr := h.executeConfig(ctx, command)
+	err := h.sendMsg(ctx, c, msg)
+	return HandleResult{
+		CommandError: commandErr,
+	}, err
+}
+
+func (h *DefaultCommandHandler) executeConfig(ctx context.Context, command protocol.Command) (protocol.Reply, error) {
+	subcommand, args := strings.ToUpper(command.Args[0]), command.Args[1:]
+	switch subcommand {
+	case "GET":
+		if len(args) > 0 {
+			var reply protocol.MapReply
+			for _, param := range h.config.Get(args...) {
+				reply = append(reply, protocol.KeyValue{
+					Key:   protocol.BulkStringReply(param[0]),
+					Value: protocol.BulkStringReply(param[1]),
+				})
+			}
+			return reply, nil
+		}
+	case "SET":
+		if len(args) > 0 && len(args)%2 == 0 {
+			return h.configSet(ctx, args)
+		}
+	case "RESETSTAT":
+		if len(args) == 0 {
+			h.stats.commands.Store(0)
+			h.stats.errorReplies.Store(0)
+			return protocol.OK, nil
+		}
+	case "REWRITE":
+		if len(args) == 0 {
+			return h.configRewrite(ctx)
+		}
+	case "HELP":
+		return protocol.ArrayReply{
+			protocol.SimpleStringReply("CONFIG <subcommand> [<arg> [value] [opt] ...]. Subcommands are:"),
+			protocol.SimpleStringReply("GET <pattern>"),
+			protocol.SimpleStringReply("    Return parameters matching the glob-like <pattern> and their values."),
+			protocol.SimpleStringReply("SET <directive> <value>"),
+			protocol.SimpleStringReply("    Set the configuration <directive> to <value>."),
+			protocol.SimpleStringReply("RESETSTAT"),
+			protocol.SimpleStringReply("    Reset statistics reported by the INFO command."),
+			protocol.SimpleStringReply("REWRITE"),
+			protocol.SimpleStringReply("    Rewrite the configuration file."),
+			protocol.SimpleStringReply("HELP"),
+			protocol.SimpleStringReply("    Print this help."),
+		}, nil
+	default:
+		err := protocol.ErrUnknownSubcommand(command.Name, command.Args[0])
+		return err, err
+	}
+	err := protocol.ErrWrongArity(command.Name + "|" + subcommand)
+	return err, err
+}
+
+// configSet changes the parameters given as pairs of names and values, and
+// passes the new values on to the components that read them only once.
+func (h *DefaultCommandHandler) configSet(ctx context.Context, args []string) (protocol.Reply, error) {
+	pairs := make([][2]string, 0, len(args)/2)
+	for i := 0; i < len(args); i += 2 {
+		pairs = append(pairs, [2]string{args[i], args[i+1]})
+	}
+	if err := h.config.Set(pairs); err != nil {
+		var paramErr *config.ParamError
+		if !errors.As(err, &paramErr) {
+			replyErr := protocol.NewError("CONFIG SET failed - " + err.Error())
+			return replyErr, replyErr
+		}
+		if errors.Is(err, config.ErrUnknownParam) {
+			replyErr := protocol.NewError(fmt.Sprintf(
+				"Unknown option or number of arguments for CONFIG SET - '%s'", paramErr.Name,
+			))
+			return replyErr, replyErr
+		}
+		replyErr := protocol.NewError(fmt.Sprintf(
+			"CONFIG SET failed (possibly related to argument '%s') - %s", paramErr.Name, paramErr.Err,
+		))
+		return replyErr, replyErr
+	}
+
+	if h.aof != nil {
+		fsync, rewritePercentage, rewriteMinSize := h.config.AOFParams()
+		if err := h.aof.SetFsync(fsync); err != nil {
+			zerolog.Ctx(ctx).Err(err).Msg("Failed to fsync the AOF file")
+		}
+		h.aof.SetAutoRewrite(rewritePercentage, rewriteMinSize)
+	}
+	return protocol.OK, nil
+}
+
+func (h *DefaultCommandHandler) configRewrite(ctx context.Context) (protocol.Reply, error) {
+	if h.config.File == "" {
+		err := protocol.NewError("The server is running without a config file")
+		return err, err
+	}
+	if err := h.config.Rewrite(); err != nil {
+		zerolog.Ctx(ctx).Err(err).Str("path", h.config.File).Msg("Failed to rewrite the config file")
+		replyErr := protocol.NewError("Rewriting config file: " + err.Error())
+		return replyErr, replyErr
+	}
+	zerolog.Ctx(ctx).Info().Str("path", h.config.File).Msg("Config file rewritten")
+	return protocol.OK, nil
+}
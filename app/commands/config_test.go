@@ -0,0 +1,151 @@
+package commands
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jorzel/myredis/app/config"
+	"github.com/jorzel/myredis/app/persistence"
+	"github.com/jorzel/myredis/app/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		expected string
+	}{
+		{
+			name:     "get",
+			args:     []string{"GET", "port"},
+			expected: "*2\r\n$4\r\nport\r\n$4\r\n6379\r\n",
+		},
+		{
+			name:     "get several patterns",
+			args:     []string{"GET", "appendfs*", "dbfilename"},
+			expected: "*4\r\n$10\r\ndbfilename\r\n$8\r\ndump.rdb\r\n$11\r\nappendfsync\r\n$8\r\neverysec\r\n",
+		},
+		{
+			name:     "get without match",
+			args:     []string{"GET", "nope"},
+			expected: "*0\r\n",
+		},
+		{
+			name:     "get without pattern",
+			args:     []string{"GET"},
+			expected: "-ERR wrong number of arguments for 'config|get' command\r\n",
+		},
+		{
+			name:     "set",
+			args:     []string{"SET", "save", "60 1"},
+			expected: "+OK\r\n",
+		},
+		{
+			name:     "set without value",
+			args:     []string{"SET", "save"},
+			expected: "-ERR wrong number of arguments for 'config|set' command\r\n",
+		},
+		{
+			name:     "set unknown parameter",
+			args:     []string{"SET", "nope", "1"},
+			expected: "-ERR Unknown option or number of arguments for CONFIG SET - 'nope'\r\n",
+		},
+		{
+			name:     "set immutable parameter",
+			args:     []string{"SET", "port", "7000"},
+			expected: "-ERR CONFIG SET failed (possibly related to argument 'port') - can't set immutable config\r\n",
+		},
+		{
+			name:     "set invalid value",
+			args:     []string{"SET", "appendfsync", "sometimes"},
+			expected: "-ERR CONFIG SET failed (possibly related to argument 'appendfsync') - appendfsync must be always, everysec or no, got sometimes\r\n",
+		},
+		{
+			name:     "rewrite without config file",
+			args:     []string{"REWRITE"},
+			expected: "-ERR The server is running without a config file\r\n",
+		},
+		{
+			name:     "unknown subcommand",
+			args:     []string{"NOPE"},
+			expected: "-ERR unknown subcommand 'NOPE'. Try CONFIG HELP.\r\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := NewCommandHandler(config.Default())
+			conn := &MockConn{}
+
+			_, err := handle(handler, conn, protocol.NewCommand("CONFIG", tt.args))
+
+			require.NoError(t, err)
+			require.Len(t, conn.writes, 1)
+			assert.Equal(t, tt.expected, string(conn.writes[0]))
+		})
+	}
+}
+
+func TestHandleConfigSetChangesAOF(t *testing.T) {
+	cfg := config.Default()
+	a := persistence.NewAOF(filepath.Join(t.TempDir(), "appendonly.aof"), cfg.AppendFsync)
+	require.NoError(t, a.Open(context.Background()))
+	defer a.Close()
+	handler := NewCommandHandler(cfg, WithAOF(a))
+	conn := &MockConn{}
+
+	handle(handler, conn, protocol.NewCommand("CONFIG", []string{
+		"SET", "auto-aof-rewrite-percentage", "100", "auto-aof-rewrite-min-size", "0",
+	}))
+	handle(handler, conn, protocol.NewCommand("SET", []string{"key", "value"}))
+
+	require.Len(t, conn.writes, 2)
+	assert.Equal(t, "+OK\r\n", string(conn.writes[0]))
+	// The file was empty when it was opened, so only a rewrite sets its base size.
+	require.Eventually(t, func() bool {
+		_, base := a.Size()
+		return !a.RewriteInProgress() && base > 0
+	}, time.Second, time.Millisecond, "Expected the new settings to trigger a rewrite")
+}
+
+func TestHandleConfigRewrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "redis.conf")
+	require.NoError(t, os.WriteFile(path, []byte("port 7000\n"), 0o644))
+	cfg := config.Default()
+	require.NoError(t, cfg.LoadFile(path))
+	handler := NewCommandHandler(cfg)
+	conn := &MockConn{}
+
+	handle(handler, conn, protocol.NewCommand("CONFIG", []string{"SET", "save", ""}))
+	_, err := handle(handler, conn, protocol.NewCommand("CONFIG", []string{"REWRITE"}))
+
+	require.NoError(t, err)
+	require.Len(t, conn.writes, 2)
+	assert.Equal(t, "+OK\r\n", string(conn.writes[1]))
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "port 7000\n# Generated by CONFIG REWRITE\nsave \"\"\n", string(data))
+}
+
+func TestHandleConfigResetstat(t *testing.T) {
+	handler := NewCommandHandler(config.Default())
+	conn := &MockConn{}
+	handle(handler, conn, protocol.NewCommand("PING", nil))
+	handle(handler, conn, protocol.NewCommand("NOPE", nil))
+	handle(handler, conn, protocol.NewCommand("INFO", []string{"stats"}))
+	require.Len(t, conn.writes, 3)
+	assert.Contains(t, string(conn.writes[2]), "total_commands_processed:2\r\n")
+	assert.Contains(t, string(conn.writes[2]), "total_error_replies:1\r\n")
+
+	handle(handler, conn, protocol.NewCommand("CONFIG", []string{"RESETSTAT"}))
+	handle(handler, conn, protocol.NewCommand("INFO", []string{"stats"}))
+
+	require.Len(t, conn.writes, 5)
+	assert.Equal(t, "+OK\r\n", string(conn.writes[3]))
+	assert.Contains(t, string(conn.writes[4]), "total_commands_processed:1\r\n")
+	assert.Contains(t, string(conn.writes[4]), "total_error_replies:0\r\n")
+}
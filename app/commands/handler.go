@@ -2,15 +2,19 @@ package commands
 
 import (
 	"context"
-	"encoding/hex"
 	"fmt"
-	"net"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/jorzel/myredis/app/client"
 	"github.com/jorzel/myredis/app/config"
+	"github.com/jorzel/myredis/app/persistence"
 	"github.com/jorzel/myredis/app/protocol"
+	"github.com/jorzel/myredis/app/rdb"
+	"github.com/jorzel/myredis/app/replication"
 	"github.com/jorzel/myredis/app/storage"
 	"github.com/rs/zerolog"
 )
@@ -20,59 +24,203 @@ type HandleResult struct {
 }
 
 type CommandHandler interface {
-	Handle(ctx context.Context, conn net.Conn, command protocol.Command) (HandleResult, error)
+	Handle(ctx context.Context, c *client.Client, command protocol.Command) (HandleResult, error)
+}
+
+// RoleSwitcher changes the replication role of the server at runtime.
+type RoleSwitcher interface {
+	// ReplicaOf makes the server a replica of master, or a master if master is nil.
+	ReplicaOf(ctx context.Context, master *config.Node) error
 }
 
 var _ CommandHandler = (*DefaultCommandHandler)(nil)
 
 type DefaultCommandHandler struct {
-	storage storage.Storage
-	config  *config.Config
+	storage     storage.Storage
+	config      *config.Config
+	replication *replication.Master
+	// link points to the followed master when the server is a replica.
+	link         *replication.Link
+	roleSwitcher RoleSwitcher
+	shutdowner   Shutdowner
+	// rdb saves snapshots of the storage, nil if RDB persistence is off.
+	rdb *persistence.RDB
+	// aof logs write commands, nil if AOF persistence is off.
+	aof *persistence.AOF
+	// writeMu serializes write commands, so they reach replicas
+	// in the same order they were applied to the storage.
+	writeMu sync.Mutex
+	stats   stats
+}
+
+// stats counts what INFO stats reports, until CONFIG RESETSTAT.
+type stats struct {
+	commands     atomic.Int64
+	errorReplies atomic.Int64
+}
+
+// Option customizes the dependencies of DefaultCommandHandler.
+type Option func(*DefaultCommandHandler)
+
+// WithStorage makes the handler operate on the given storage.
+func WithStorage(s storage.Storage) Option {
+	return func(h *DefaultCommandHandler) {
+		h.storage = s
+	}
+}
+
+// WithReplication makes the handler register replicas in, and propagate
+// writes through, the given replication master.
+func WithReplication(m *replication.Master) Option {
+	return func(h *DefaultCommandHandler) {
+		h.replication = m
+	}
+}
+
+// WithLink makes the handler report the state of the given link to a master.
+func WithLink(l *replication.Link) Option {
+	return func(h *DefaultCommandHandler) {
+		h.link = l
+	}
+}
+
+// WithRoleSwitcher lets the handler change the replication role of the server.
+func WithRoleSwitcher(rs RoleSwitcher) Option {
+	return func(h *DefaultCommandHandler) {
+		h.roleSwitcher = rs
+	}
+}
+
+// WithShutdowner lets the handler stop the server.
+func WithShutdowner(s Shutdowner) Option {
+	return func(h *DefaultCommandHandler) {
+		h.shutdowner = s
+	}
+}
+
+// WithRDB makes the handler save snapshots of the storage to the given RDB file.
+func WithRDB(r *persistence.RDB) Option {
+	return func(h *DefaultCommandHandler) {
+		h.rdb = r
+	}
+}
+
+// WithAOF makes the handler log write commands to the given append-only file.
+func WithAOF(a *persistence.AOF) Option {
+	return func(h *DefaultCommandHandler) {
+		h.aof = a
+	}
 }
 
-// NewCommandHandler creates a new CommandHandler with an empty storage.
-func NewCommandHandler(config *config.Config) CommandHandler {
-	return &DefaultCommandHandler{
-		config:  config,
-		storage: storage.NewStorage(),
+// NewCommandHandler creates a new CommandHandler. Unless overridden by options,
+// it acts as a master with an empty storage and its own replication master.
+func NewCommandHandler(config *config.Config, opts ...Option) CommandHandler {
+	h := &DefaultCommandHandler{
+		config:      config,
+		storage:     storage.NewStorage(),
+		replication: replication.NewMaster(),
+		link:        replication.NewLink(nil),
 	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
 }
 
+// Handle looks command up in the registry, checks its arity and runs its
+// handler. Write commands are serialized and propagated to replicas.
 func (h *DefaultCommandHandler) Handle(
-	ctx context.Context, conn net.Conn, command protocol.Command,
+	ctx context.Context, c *client.Client, command protocol.Command,
 ) (HandleResult, error) {
-	switch command.Name {
-	case protocol.PING:
-		return h.handlePing(ctx, conn, command)
-	case protocol.SET:
-		return h.handleSet(ctx, conn, command)
-	case protocol.GET:
-		return h.handleGet(ctx, conn, command)
-	case protocol.DEL:
-		return h.handleDel(ctx, conn, command) // DEL is not implemented
-	case protocol.REPLCONF:
-		return h.handleReplConf(ctx, conn, command)
-	case protocol.PSYNC:
-		return h.handlePsync(ctx, conn, command)
-	case protocol.FULLRESYNC:
-		return h.handleFullresync(ctx, conn, command)
-	default:
-		return h.handleUnknownCommand(ctx, conn, command)
+	result, err := h.dispatch(ctx, c, command)
+	h.stats.commands.Add(1)
+	if result.CommandError != nil {
+		h.stats.errorReplies.Add(1)
+	}
+	return result, err
+}
+
+func (h *DefaultCommandHandler) dispatch(
+	ctx context.Context, c *client.Client, command protocol.Command,
+) (HandleResult, error) {
+	spec, ok := Lookup(command.Name)
+	if !ok {
+		return h.handleUnknownCommand(ctx, c, command)
 	}
+	if !spec.CheckArity(len(command.Args) + 1) {
+		commandErr := protocol.ErrWrongArity(command.Name)
+		err := h.sendMsg(ctx, c, commandErr)
+		return HandleResult{
+			CommandError: commandErr,
+		}, err
+	}
+	if !spec.Has(FlagWrite) {
+		return spec.handle(h, ctx, c, command)
+	}
+
+	command = withAbsoluteExpiry(command)
+	h.writeMu.Lock()
+	defer h.writeMu.Unlock()
+	result, err := spec.handle(h, ctx, c, command)
+	if result.CommandError != nil {
+		return result, err
+	}
+	// Replicas feed sub-replicas with the stream received from their master,
+	// and writes from their own clients stay local.
+	if !h.isReplica() {
+		h.replication.Propagate(ctx, command)
+	}
+	if h.rdb != nil {
+		h.rdb.AddChanges(1)
+	}
+	// The reply is still buffered, so with appendfsync always the write is
+	// on disk before the client learns about it.
+	if h.aof != nil {
+		if err := h.aof.Append(command); err != nil {
+			zerolog.Ctx(ctx).Err(err).Str("command", command.Name).Msg("Failed to log command to the AOF file")
+		}
+		if h.aof.NeedsRewrite() {
+			h.rewriteAOF(ctx)
+		}
+	}
+	return result, err
+}
+
+// withAbsoluteExpiry rewrites SET with a relative PX expiry to PXAT, as Redis
+// does, so the time to live is not extended when the command is replayed
+// from the AOF or by a lagging replica.
+func withAbsoluteExpiry(command protocol.Command) protocol.Command {
+	if !strings.EqualFold(command.Name, protocol.SET) || len(command.Args) != 4 ||
+		!strings.EqualFold(command.Args[2], "px") {
+		return command
+	}
+	expiration, err := strconv.ParseInt(command.Args[3], 10, 64)
+	if err != nil || expiration <= 0 {
+		// SET itself reports the invalid expiry.
+		return command
+	}
+	expireAt := time.Now().Add(time.Duration(expiration) * time.Millisecond).UnixMilli()
+	return protocol.NewCommand(command.Name, []string{
+		command.Args[0], command.Args[1], "PXAT", strconv.FormatInt(expireAt, 10),
+	})
 }
 
-func (h *DefaultCommandHandler) sendMsg(_ context.Context, conn net.Conn, msg []byte) error {
-	if _, err := conn.Write(msg); err != nil {
+func (h *DefaultCommandHandler) isReplica() bool {
+	return h.link.Master() != nil
+}
+
+func (h *DefaultCommandHandler) sendMsg(_ context.Context, c *client.Client, msg protocol.Reply) error {
+	if err := c.WriteReply(msg); err != nil {
 		return fmt.Errorf("Failed to write response: " + err.Error())
 	}
 	return nil
 }
 
 func (h *DefaultCommandHandler) handleUnknownCommand(
-	ctx context.Context, conn net.Conn, command protocol.Command,
+	ctx context.Context, c *client.Client, command protocol.Command,
 ) (HandleResult, error) {
 	msg, commandErr := h.executeUnknownCommand(ctx, command)
-	err := h.sendMsg(ctx, conn, msg)
+	err := h.sendMsg(ctx, c, msg)
 	return HandleResult{
 		CommandError: commandErr,
 	}, err
@@ -80,109 +228,129 @@ func (h *DefaultCommandHandler) handleUnknownCommand(
 
 func (h *DefaultCommandHandler) executeUnknownCommand(
 	_ context.Context, command protocol.Command,
-) ([]byte, error) {
-	errMsg := fmt.Sprintf("Unknown command: %s", command.Name)
-	return protocol.Error(errMsg), fmt.Errorf(errMsg)
+) (protocol.Reply, error) {
+	err := protocol.ErrUnknownCommand(command.Name, command.Args)
+	return err, err
 }
 
 func (h *DefaultCommandHandler) handlePing(
-	ctx context.Context, conn net.Conn, command protocol.Command,
+	ctx context.Context, c *client.Client, command protocol.Command,
 ) (HandleResult, error) {
 	msg, commandErr := h.executePing(ctx, command)
-	err := h.sendMsg(ctx, conn, msg)
+	err := h.sendMsg(ctx, c, msg)
+	return HandleResult{
+		CommandError: commandErr,
+	}, err
+}
+
+func (h *DefaultCommandHandler) executePing(_ context.Context, command protocol.Command) (protocol.Reply, error) {
+	switch len(command.Args) {
+	case 0:
+		return protocol.SimpleStringReply("PONG"), nil
+	case 1:
+		return protocol.BulkStringReply(command.Args[0]), nil
+	default:
+		err := protocol.ErrWrongArity(command.Name)
+		return err, err
+	}
+}
+
+func (h *DefaultCommandHandler) handleEcho(
+	ctx context.Context, c *client.Client, command protocol.Command,
+) (HandleResult, error) {
+	msg, commandErr := h.executeEcho(ctx, command)
+	err := h.sendMsg(ctx, c, msg)
 	return HandleResult{
 		CommandError: commandErr,
 	}, err
 }
 
-func (h *DefaultCommandHandler) executePing(_ context.Context, command protocol.Command) ([]byte, error) {
-	return protocol.SimpleString("PONG"), nil
+func (h *DefaultCommandHandler) executeEcho(_ context.Context, command protocol.Command) (protocol.Reply, error) {
+	return protocol.BulkStringReply(command.Args[0]), nil
 }
 
 func (h *DefaultCommandHandler) handleSet(
-	ctx context.Context, conn net.Conn, command protocol.Command,
+	ctx context.Context, c *client.Client, command protocol.Command,
 ) (HandleResult, error) {
 	msg, commandErr := h.executeSet(ctx, command)
 	var err error
-	err = h.sendMsg(ctx, conn, msg)
+	err = h.sendMsg(ctx, c, msg)
 	return HandleResult{
 		CommandError: commandErr,
 	}, err
 }
 
-func (h *DefaultCommandHandler) executeSet(_ context.Context, command protocol.Command) ([]byte, error) {
-	if len(command.Args) < 2 {
-		errMsg := "SET command requires at least 2 arguments"
-		return protocol.Error(errMsg), fmt.Errorf(errMsg)
-	}
+func (h *DefaultCommandHandler) executeSet(_ context.Context, command protocol.Command) (protocol.Reply, error) {
 	record := storage.KVRecord{
 		Value: command.Args[1],
 	}
 	if len(command.Args) > 2 {
 		// If an expiration time is provided, parse it
-		if strings.ToLower(command.Args[2]) == "px" {
-			if len(command.Args) < 4 {
-				errMsg := "SET command with PX requires 4 arguments"
-				return protocol.Error(errMsg), fmt.Errorf(errMsg)
-			}
-			expiration, err := time.ParseDuration(command.Args[3] + "ms")
-			if err != nil {
-				errMsg := "Invalid expiration time: " + err.Error()
-				return protocol.Error(errMsg), fmt.Errorf(errMsg)
-			}
-			expireAt := time.Now().Add(expiration)
-			record.ExpireAt = &expireAt
+		option := strings.ToLower(command.Args[2])
+		if option != "px" && option != "pxat" {
+			return protocol.ErrSyntax, protocol.ErrSyntax
+		}
+		if len(command.Args) < 4 {
+			return protocol.ErrSyntax, protocol.ErrSyntax
+		}
+		expiration, err := strconv.ParseInt(command.Args[3], 10, 64)
+		if err != nil {
+			return protocol.ErrNotInteger, protocol.ErrNotInteger
+		}
+		if expiration <= 0 {
+			err := protocol.ErrInvalidExpire(command.Name)
+			return err, err
+		}
+		var expireAt time.Time
+		if option == "px" {
+			expireAt = time.Now().Add(time.Duration(expiration) * time.Millisecond)
 		} else {
-			errMsg := "Unsupported expiration format, only PX is supported"
-			return protocol.Error(errMsg), fmt.Errorf(errMsg)
+			expireAt = time.UnixMilli(expiration)
 		}
+		record.ExpireAt = &expireAt
 	}
 
 	h.storage.Set(command.Args[0], &record)
-	return protocol.SimpleString("OK"), nil
+	return protocol.OK, nil
 }
 
 func (h *DefaultCommandHandler) handleGet(
-	ctx context.Context, conn net.Conn, command protocol.Command,
+	ctx context.Context, c *client.Client, command protocol.Command,
 ) (HandleResult, error) {
 	msg, commandErr := h.executeGet(ctx, command)
-	err := h.sendMsg(ctx, conn, msg)
+	err := h.sendMsg(ctx, c, msg)
 	return HandleResult{
 		CommandError: commandErr,
 	}, err
 }
 
-func (h *DefaultCommandHandler) executeGet(_ context.Context, command protocol.Command) ([]byte, error) {
-	if len(command.Args) != 1 {
-		errMsg := "GET command requires exactly 1 argument"
-		return protocol.Error(errMsg), fmt.Errorf(errMsg)
-	}
+func (h *DefaultCommandHandler) executeGet(_ context.Context, command protocol.Command) (protocol.Reply, error) {
 	deserializedRecord, err := h.storage.Get(command.Args[0])
 	if err != nil {
-		errMsg := "Failed to get record: " + err.Error()
-		return protocol.Error(errMsg), fmt.Errorf(errMsg)
+		replyErr := protocol.NewError("Failed to get record: " + err.Error())
+		return replyErr, replyErr
 	}
 	if deserializedRecord == nil {
-		return protocol.Nil(), nil
+		return protocol.NullReply{}, nil
 	}
 	if deserializedRecord.ExpireAt != nil && deserializedRecord.ExpireAt.Before(time.Now()) {
 		// If the record has expired, return nil
-		return protocol.Nil(), nil
+		return protocol.NullReply{}, nil
 	}
-	return protocol.BulkString(deserializedRecord.Value), nil
+	return protocol.BulkStringReply(deserializedRecord.Value), nil
 }
 
 func (h *DefaultCommandHandler) handleDel(
-	ctx context.Context, conn net.Conn, command protocol.Command,
+	ctx context.Context, c *client.Client, command protocol.Command,
 ) (HandleResult, error) {
 	msg, commandErr := h.executeDel(ctx, command)
-	err := h.sendMsg(ctx, conn, msg)
+	err := h.sendMsg(ctx, c, msg)
 	return HandleResult{
 		CommandError: commandErr,
 	}, err
 }
 
-func (h *DefaultCommandHandler) executeDel(_ context.Context, command protocol.Command) ([]byte, error) {
+func (h *DefaultCommandHandler) executeDel(_ context.Context, command protocol.Command) (protocol.Reply, error) {
 	count := 0
 	for i := 0; i < len(command.Args); i++ {
 		err := h.storage.Del(command.Args[i])
@@ -191,16 +359,16 @@ func (h *DefaultCommandHandler) executeDel(_ context.Context, command protocol.C
 		}
 		count++
 	}
-	return protocol.SimpleInteger(count), nil
+	return protocol.IntegerReply(count), nil
 }
 
 func (h *DefaultCommandHandler) handleReplConf(
-	ctx context.Context, conn net.Conn, command protocol.Command,
+	ctx context.Context, c *client.Client, command protocol.Command,
 ) (HandleResult, error) {
 	var err error
-	msg, commandErr := h.executeReplConf(ctx, conn, command)
+	msg, commandErr := h.executeReplConf(ctx, c, command)
 	if msg != nil {
-		err = h.sendMsg(ctx, conn, msg)
+		err = h.sendMsg(ctx, c, msg)
 	}
 	return HandleResult{
 		CommandError: commandErr,
@@ -208,86 +376,221 @@ func (h *DefaultCommandHandler) handleReplConf(
 }
 
 func (h *DefaultCommandHandler) executeReplConf(
-	ctx context.Context, conn net.Conn, command protocol.Command,
-) ([]byte, error) {
-	if len(command.Args) != 2 {
-		errMsg := "REPLCONF command requires exactly 2 arguments"
-		return protocol.Error(errMsg), fmt.Errorf(errMsg)
+	ctx context.Context, c *client.Client, command protocol.Command,
+) (protocol.Reply, error) {
+	// Options come in name and value pairs, several of them at once from
+	// Redis replicas, such as REPLCONF capa eof capa psync2.
+	if len(command.Args)%2 != 0 {
+		return protocol.ErrSyntax, protocol.ErrSyntax
 	}
 
-	if strings.ToLower(command.Args[0]) == "listening-port" {
-		return protocol.SimpleString("OK"), nil
-	} else if strings.ToLower(command.Args[0]) == "capa" && strings.ToLower(command.Args[1]) == "psync2" {
-		return protocol.SimpleString("OK"), nil
+	for i := 0; i < len(command.Args); i += 2 {
+		name, value := command.Args[i], command.Args[i+1]
+		switch strings.ToLower(name) {
+		case "listening-port":
+			port, err := strconv.Atoi(value)
+			if err != nil || port < 0 || port > 65535 {
+				return protocol.ErrNotInteger, protocol.ErrNotInteger
+			}
+			h.replication.SetListeningPort(c, port)
+		case "ip-address":
+			h.replication.SetAnnouncedIP(c, value)
+		case "capa":
+			// psync2 is always supported, other capabilities such as eof
+			// (diskless loading) are ignored as in Redis.
+		case "ack":
+			offset, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return nil, protocol.ErrNotInteger
+			}
+			// Acknowledgements are never replied to.
+			if !h.replication.Ack(c, offset) {
+				return nil, fmt.Errorf("REPLCONF ACK received from a connection that is not a replica")
+			}
+			return nil, nil
+		case "getack":
+			// GETACK is answered by replicas on the master link only.
+			return nil, nil
+		default:
+			err := protocol.NewError("Unrecognized REPLCONF option: " + name)
+			return err, err
+		}
 	}
-	errMsg := fmt.Sprintf(
-		"REPLCONF command only supports 'listening-port <PORT>', and 'capa psync2' arguments, got: %s",
-		strings.Join(command.Args, ", "),
-	)
-	return protocol.Error(errMsg), fmt.Errorf(errMsg)
+	return protocol.OK, nil
 }
 
 func (h *DefaultCommandHandler) handlePsync(
-	ctx context.Context, conn net.Conn, command protocol.Command,
+	ctx context.Context, c *client.Client, command protocol.Command,
 ) (HandleResult, error) {
 	logger := zerolog.Ctx(ctx)
 
-	msg, commandErr := h.executePsync(ctx, conn, command)
-	err := h.sendMsg(ctx, conn, msg)
+	// Writes are held back until the replica is registered, so none of them
+	// falls between the snapshot and the propagated stream.
+	h.writeMu.Lock()
+	defer h.writeMu.Unlock()
+
+	msg, offset, fullResync, commandErr := h.executePsync(ctx, command)
+	err := h.sendMsg(ctx, c, msg)
 	if err != nil || commandErr != nil {
 		return HandleResult{
 			CommandError: commandErr,
 		}, err
 	}
-	//time.Sleep(100 * time.Millisecond) // Simulate some delay
-	msg, commandErr = h.getDBFile(ctx)
+	if fullResync {
+		msg, commandErr = h.getDBFile(ctx)
+		err = h.sendMsg(ctx, c, msg)
+		logger.Info().Msg("Sending DB file to replica")
+		if err != nil || commandErr != nil {
+			return HandleResult{
+				CommandError: commandErr,
+			}, err
+		}
+	}
+
+	replica, err := h.replication.AddReplica(c, offset)
+	if err != nil {
+		return HandleResult{}, fmt.Errorf("failed to register replica: %w", err)
+	}
+	logger.Info().
+		Str("replica", replica.Addr()).
+		Int64("offset", offset).
+		Bool("full_resync", fullResync).
+		Msg("Replica registered for command propagation")
+	return HandleResult{}, nil
+}
+
+// executePsync decides between a partial and a full resynchronization. Besides
+// the reply it returns the offset the replica continues from.
+func (h *DefaultCommandHandler) executePsync(
+	_ context.Context, command protocol.Command,
+) (protocol.Reply, int64, bool, error) {
+	replID := command.Args[0]
+	requestedOffset, err := strconv.ParseInt(command.Args[1], 10, 64)
+	if err != nil {
+		return protocol.ErrNotInteger, 0, false, protocol.ErrNotInteger
+	}
+
+	// The replica asks for the first byte it is missing, offsets of which start
+	// from 1, so it has already processed everything up to requestedOffset-1.
+	if replID != "?" && h.replication.CanContinue(replID, requestedOffset-1) {
+		return protocol.SimpleStringReply(protocol.CONTINUE + " " + h.replication.ReplID()),
+			requestedOffset - 1, false, nil
+	}
+
+	offset := h.replication.Offset()
+	reply := fmt.Sprintf("%s %s %d", protocol.FULLRESYNC, h.replication.ReplID(), offset)
+	return protocol.SimpleStringReply(reply), offset, true, nil
+}
+
+func (h *DefaultCommandHandler) getDBFile(_ context.Context) (protocol.Reply, error) {
+	return protocol.RawReply(protocol.FileContent(rdb.Dump(h.storage))), nil
+}
 
-	err = h.sendMsg(ctx, conn, msg)
-	logger.Info().Msg("Sending DB file to replica")
+func (h *DefaultCommandHandler) handleWait(
+	ctx context.Context, c *client.Client, command protocol.Command,
+) (HandleResult, error) {
+	msg, commandErr := h.executeWait(ctx, command)
+	err := h.sendMsg(ctx, c, msg)
 	return HandleResult{
 		CommandError: commandErr,
 	}, err
 }
 
-func (h *DefaultCommandHandler) executePsync(
-	ctx context.Context, conn net.Conn, command protocol.Command,
-) ([]byte, error) {
-	if len(command.Args) != 2 {
-		errMsg := "PSYNC command requires exactly 2 arguments"
-		return protocol.Error(errMsg), fmt.Errorf(errMsg)
+func (h *DefaultCommandHandler) executeWait(ctx context.Context, command protocol.Command) (protocol.Reply, error) {
+	numReplicas, err := strconv.Atoi(command.Args[0])
+	if err != nil {
+		return protocol.ErrNotInteger, protocol.ErrNotInteger
+	}
+	timeout, err := strconv.Atoi(command.Args[1])
+	if err != nil {
+		return protocol.ErrNotInteger, protocol.ErrNotInteger
 	}
-	if command.Args[0] != "?" || command.Args[1] != "-1" {
-		errMsg := "PSYNC command only supports '?' and '-1' arguments"
-		return protocol.Error(errMsg), fmt.Errorf(errMsg)
+	if timeout < 0 {
+		return protocol.ErrNegativeTTL, protocol.ErrNegativeTTL
+	}
+	if h.isReplica() {
+		err := protocol.NewError("WAIT cannot be used with replica instances. " +
+			"Please also note that since Redis 4.0 if a replica is configured to be writable " +
+			"(which is not the default) writes to replicas are just local and are not propagated.")
+		return err, err
 	}
-	replID := "test"
 
-	return protocol.SimpleString("FULLRESYNC " + replID + " 0"), nil
+	acked := h.replication.WaitForAcks(ctx, numReplicas, time.Duration(timeout)*time.Millisecond)
+	return protocol.IntegerReply(acked), nil
 }
 
-func (h *DefaultCommandHandler) getDBFile(_ context.Context) ([]byte, error) {
-	// hex-encoded representation of a empty db file
-	emptyDBFile := "524544495330303131fa0972656469732d76657205372e322e30fa0a72656469732d62697473c040fa056374696d65c26d08bc65fa08757365642d6d656dc2b0c41000fa08616f662d62617365c000fff06e3bfec0ff5aa2"
-	DBContent, _ := hex.DecodeString(emptyDBFile)
-	return protocol.FileContent(DBContent), nil
+func (h *DefaultCommandHandler) handleReplicaOf(
+	ctx context.Context, c *client.Client, command protocol.Command,
+) (HandleResult, error) {
+	msg, commandErr := h.executeReplicaOf(ctx, command)
+	err := h.sendMsg(ctx, c, msg)
+	return HandleResult{
+		CommandError: commandErr,
+	}, err
 }
 
-func (h *DefaultCommandHandler) handleFullresync(
-	ctx context.Context, conn net.Conn, command protocol.Command,
+func (h *DefaultCommandHandler) executeReplicaOf(ctx context.Context, command protocol.Command) (protocol.Reply, error) {
+	if h.roleSwitcher == nil {
+		err := protocol.NewError(fmt.Sprintf("%s is not supported by this server", command.Name))
+		return err, err
+	}
+
+	var master *config.Node
+	if !strings.EqualFold(command.Args[0], "NO") || !strings.EqualFold(command.Args[1], "ONE") {
+		port, err := strconv.Atoi(command.Args[1])
+		if err != nil || port < 1 || port > 65535 {
+			if err == nil {
+				err := protocol.NewError("Invalid master port")
+				return err, err
+			}
+			return protocol.ErrNotInteger, protocol.ErrNotInteger
+		}
+		master = &config.Node{Host: command.Args[0], Port: port}
+		if current := h.link.Master(); current != nil && *current == *master {
+			return protocol.SimpleStringReply("OK Already connected to specified master"), nil
+		}
+	}
+
+	if err := h.roleSwitcher.ReplicaOf(ctx, master); err != nil {
+		replyErr := protocol.NewError("Failed to change replication role: " + err.Error())
+		return replyErr, replyErr
+	}
+	return protocol.OK, nil
+}
+
+func (h *DefaultCommandHandler) handleRole(
+	ctx context.Context, c *client.Client, command protocol.Command,
 ) (HandleResult, error) {
-	logger := zerolog.Ctx(ctx)
-	replID := command.Args[0]
-	offset, err := strconv.Atoi(command.Args[1])
-	if err != nil {
-		errMsg := "Invalid offset in FULLRESYNC command: " + err.Error()
-		return HandleResult{
-			CommandError: fmt.Errorf(errMsg),
-		}, fmt.Errorf(errMsg)
+	msg, commandErr := h.executeRole(ctx, command)
+	err := h.sendMsg(ctx, c, msg)
+	return HandleResult{
+		CommandError: commandErr,
+	}, err
+}
+
+func (h *DefaultCommandHandler) executeRole(_ context.Context, command protocol.Command) (protocol.Reply, error) {
+	if master := h.link.Master(); master != nil {
+		return protocol.ArrayReply{
+			protocol.BulkStringReply("slave"),
+			protocol.BulkStringReply(master.Host),
+			protocol.IntegerReply(master.Port),
+			protocol.BulkStringReply(h.link.State()),
+			protocol.IntegerReply(h.replication.Offset()),
+		}, nil
 	}
-	logger.Info().
-		Str("command", command.Name).
-		Int("offset", offset).
-		Str("repl_id", replID).
-		Msg("Handling FULLRESYNC command")
-	return HandleResult{}, err
+
+	replicas := h.replication.Replicas()
+	described := make(protocol.ArrayReply, 0, len(replicas))
+	for _, replica := range replicas {
+		described = append(described, protocol.BulkStrings(
+			replica.IP(),
+			strconv.Itoa(replica.ListeningPort()),
+			strconv.FormatInt(replica.AckOffset(), 10),
+		))
+	}
+	return protocol.ArrayReply{
+		protocol.BulkStringReply("master"),
+		protocol.IntegerReply(h.replication.Offset()),
+		described,
+	}, nil
 }
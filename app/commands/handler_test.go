@@ -3,11 +3,15 @@ package commands
 import (
 	"context"
 	"net"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/jorzel/myredis/app/client"
 	"github.com/jorzel/myredis/app/config"
 	"github.com/jorzel/myredis/app/protocol"
+	"github.com/jorzel/myredis/app/replication"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -17,10 +21,19 @@ var _ net.Conn = (*MockConn)(nil)
 
 type MockConn struct {
 	writes [][]byte
+	client *client.Client
+}
+
+// Client returns the client wrapping the connection, the same on every call.
+func (m *MockConn) Client() *client.Client {
+	if m.client == nil {
+		m.client = client.New(m)
+	}
+	return m.client
 }
 
 func (m *MockConn) Write(b []byte) (int, error) {
-	m.writes = append(m.writes, b)
+	m.writes = append(m.writes, append([]byte(nil), b...))
 	return len(b), nil
 }
 func (m *MockConn) Read(b []byte) (int, error) {
@@ -46,13 +59,23 @@ func (m *MockConn) SetWriteDeadline(t time.Time) error {
 	return nil
 }
 
+// handle dispatches command on behalf of the client of conn and flushes the
+// reply, as the server does once a pipeline is handled.
+func handle(handler CommandHandler, conn *MockConn, command protocol.Command) (HandleResult, error) {
+	result, err := handler.Handle(context.Background(), conn.Client(), command)
+	if flushErr := conn.Client().Flush(); err == nil {
+		err = flushErr
+	}
+	return result, err
+}
+
 func TestHandlePing(t *testing.T) {
 	command := protocol.Command{
 		Name: "PING",
 	}
 	conn := &MockConn{}
 	handler := NewCommandHandler(&config.Config{})
-	_, err := handler.Handle(context.Background(), conn, command)
+	_, err := handle(handler, conn, command)
 
 	require.NoError(t, err, "Expected no error when handling PING command")
 	require.Len(t, conn.writes, 1, "Expected one write to the connection")
@@ -67,7 +90,7 @@ func TestHandleEcho(t *testing.T) {
 
 	conn := &MockConn{}
 	handler := NewCommandHandler(&config.Config{})
-	_, err := handler.Handle(context.Background(), conn, command)
+	_, err := handle(handler, conn, command)
 
 	require.NoError(t, err, "Expected no error when handling ECHO command")
 	require.Len(t, conn.writes, 1, "Expected one write to the connection")
@@ -82,7 +105,7 @@ func TestHandleSet(t *testing.T) {
 
 	conn := &MockConn{}
 	handler := NewCommandHandler(&config.Config{})
-	_, err := handler.Handle(context.Background(), conn, command)
+	_, err := handle(handler, conn, command)
 
 	require.NoError(t, err, "Expected no error when handling SET command")
 	require.Len(t, conn.writes, 1, "Expected one write to the connection")
@@ -97,13 +120,31 @@ func TestHandleSetWithPX(t *testing.T) {
 
 	conn := &MockConn{}
 	handler := NewCommandHandler(&config.Config{})
-	_, err := handler.Handle(context.Background(), conn, command)
+	_, err := handle(handler, conn, command)
 
 	require.NoError(t, err, "Expected no error when handling SET command with PX")
 	require.Len(t, conn.writes, 1, "Expected one write to the connection")
 	assert.Equal(t, string([]byte("+OK\r\n")), string(conn.writes[0]), "Expected SET command to return OK")
 }
 
+func TestHandleSetWithPXAT(t *testing.T) {
+	handler := NewCommandHandler(&config.Config{})
+	conn := &MockConn{}
+	past := strconv.FormatInt(time.Now().Add(-time.Second).UnixMilli(), 10)
+	future := strconv.FormatInt(time.Now().Add(time.Hour).UnixMilli(), 10)
+
+	handle(handler, conn, protocol.NewCommand("SET", []string{"gone", "value", "PXAT", past}))
+	handle(handler, conn, protocol.NewCommand("SET", []string{"kept", "value", "pxat", future}))
+	handle(handler, conn, protocol.NewCommand("GET", []string{"gone"}))
+	handle(handler, conn, protocol.NewCommand("GET", []string{"kept"}))
+
+	require.Len(t, conn.writes, 4, "Expected four writes to the connection")
+	assert.Equal(t, "+OK\r\n", string(conn.writes[0]))
+	assert.Equal(t, "+OK\r\n", string(conn.writes[1]))
+	assert.Equal(t, "$-1\r\n", string(conn.writes[2]), "Expected a key expired in the past to be gone")
+	assert.Equal(t, "$5\r\nvalue\r\n", string(conn.writes[3]))
+}
+
 func TestHandleGet(t *testing.T) {
 	getCommand := protocol.Command{
 		Name: "GET",
@@ -117,9 +158,9 @@ func TestHandleGet(t *testing.T) {
 		Args: []string{"key", "value"},
 	}
 	conn := &MockConn{}
-	handler.Handle(context.Background(), conn, setCommand)
+	handle(handler, conn, setCommand)
 
-	_, err := handler.Handle(context.Background(), conn, getCommand)
+	_, err := handle(handler, conn, getCommand)
 
 	require.NoError(t, err, "Expected no error when handling GET command")
 	require.Len(t, conn.writes, 2, "Expected one write to the connection")
@@ -144,10 +185,10 @@ func TestHandleGetExpiredRecord(t *testing.T) {
 		Args: []string{"key", "value", "px", "1"},
 	}
 	conn := &MockConn{}
-	handler.Handle(context.Background(), conn, setCommand)
+	handle(handler, conn, setCommand)
 	time.Sleep(2 * time.Millisecond) // Wait for the key to expire
 
-	_, err := handler.Handle(context.Background(), conn, getCommand)
+	_, err := handle(handler, conn, getCommand)
 
 	require.NoError(t, err, "Expected no error when handling GET command for expired key")
 	require.Len(t, conn.writes, 2, "Expected two writes to the connection")
@@ -167,9 +208,9 @@ func TestHandleDel(t *testing.T) {
 		Args: []string{"key", "value"},
 	}
 	conn := &MockConn{}
-	handler.Handle(context.Background(), conn, setCommand)
+	handle(handler, conn, setCommand)
 
-	_, err := handler.Handle(context.Background(), conn, delCommand)
+	_, err := handle(handler, conn, delCommand)
 
 	require.NoError(t, err, "Expected no error when handling DEL command")
 	require.Len(t, conn.writes, 2, "Expected two writes to the connection")
@@ -184,13 +225,54 @@ func TestHandleReplConfListeningPort(t *testing.T) {
 	handler := NewCommandHandler(&config.Config{})
 
 	conn := &MockConn{}
-	_, err := handler.Handle(context.Background(), conn, command)
+	_, err := handle(handler, conn, command)
 
 	require.NoError(t, err, "Expected no error when handling REPLCONF command")
 	require.Len(t, conn.writes, 1, "Expected one write to the connection")
 	assert.Equal(t, string([]byte("+OK\r\n")), string(conn.writes[0]), "Expected REPLCONF command to return OK")
 }
 
+func TestHandleReplConfOptions(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		expected string
+	}{
+		{
+			name:     "Capabilities of a Redis replica",
+			args:     []string{"capa", "eof", "capa", "psync2"},
+			expected: "+OK\r\n",
+		},
+		{
+			name:     "Several options at once",
+			args:     []string{"listening-port", "6380", "ip-address", "10.0.0.1", "capa", "psync2"},
+			expected: "+OK\r\n",
+		},
+		{
+			name:     "Option without a value",
+			args:     []string{"capa", "eof", "capa"},
+			expected: "-ERR syntax error\r\n",
+		},
+		{
+			name:     "Unknown option",
+			args:     []string{"rdb-only", "1"},
+			expected: "-ERR Unrecognized REPLCONF option: rdb-only\r\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := NewCommandHandler(&config.Config{})
+			conn := &MockConn{}
+
+			_, err := handle(handler, conn, protocol.Command{Name: "REPLCONF", Args: tt.args})
+
+			require.NoError(t, err)
+			require.Len(t, conn.writes, 1)
+			assert.Equal(t, tt.expected, string(conn.writes[0]))
+		})
+	}
+}
+
 func TestHandlePSync(t *testing.T) {
 	command := protocol.Command{
 		Name: "PSYNC",
@@ -199,14 +281,390 @@ func TestHandlePSync(t *testing.T) {
 
 	handler := NewCommandHandler(&config.Config{})
 	conn := &MockConn{}
-	_, err := handler.Handle(context.Background(), conn, command)
+	_, err := handle(handler, conn, command)
 
 	require.NoError(t, err, "Expected no error when handling PSYNC command")
+	require.Len(t, conn.writes, 1, "Expected FULLRESYNC and the DB file to be flushed together")
+	assert.Regexp(t,
+		"^\\+FULLRESYNC [0-9a-f]{40} 0\r\n\\$[0-9]+\r\nREDIS",
+		string(conn.writes[0]),
+		"Expected PSYNC command to return FULLRESYNC response followed by the DB file",
+	)
+}
+
+func TestHandleSetPropagatesToReplica(t *testing.T) {
+	handler := NewCommandHandler(&config.Config{})
+	replicaConn := &MockConn{}
+	_, err := handle(handler, replicaConn, protocol.Command{
+		Name: "PSYNC",
+		Args: []string{"?", "-1"},
+	})
+	require.NoError(t, err, "Expected no error when handling PSYNC command")
+
+	clientConn := &MockConn{}
+	_, err = handle(handler, clientConn, protocol.Command{
+		Name: "SET",
+		Args: []string{"key", "value"},
+	})
+	require.NoError(t, err, "Expected no error when handling SET command")
+	_, err = handle(handler, clientConn, protocol.Command{
+		Name: "GET",
+		Args: []string{"key"},
+	})
+	require.NoError(t, err, "Expected no error when handling GET command")
+
+	require.Len(t, replicaConn.writes, 2, "Expected the full resync and one propagated write")
+	assert.Equal(t,
+		"*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$5\r\nvalue\r\n",
+		string(replicaConn.writes[1]),
+		"Expected SET command to be propagated to the replica",
+	)
+}
+
+func TestHandleReplConfAck(t *testing.T) {
+	master := replication.NewMaster()
+	handler := NewCommandHandler(&config.Config{}, WithReplication(master))
+	replicaConn := &MockConn{}
+	_, err := handle(handler, replicaConn, protocol.Command{
+		Name: "PSYNC",
+		Args: []string{"?", "-1"},
+	})
+	require.NoError(t, err, "Expected no error when handling PSYNC command")
+	_, err = handle(handler, &MockConn{}, protocol.Command{
+		Name: "SET",
+		Args: []string{"key", "value"},
+	})
+	require.NoError(t, err, "Expected no error when handling SET command")
+
+	result, err := handle(handler, replicaConn, protocol.Command{
+		Name: "REPLCONF",
+		Args: []string{"ACK", "33"},
+	})
+
+	require.NoError(t, err, "Expected no error when handling REPLCONF ACK command")
+	require.NoError(t, result.CommandError, "Expected REPLCONF ACK to succeed")
+	require.Len(t, replicaConn.writes, 2, "Expected REPLCONF ACK not to be replied to")
+	assert.Equal(t, int64(33), master.Offset(), "Expected master offset to count the propagated SET")
+	require.Len(t, master.Replicas(), 1, "Expected one registered replica")
+	assert.Equal(t, int64(33), master.Replicas()[0].AckOffset(), "Expected replica ack offset to be recorded")
+}
+
+func TestHandleWaitWithoutPendingWrites(t *testing.T) {
+	handler := NewCommandHandler(&config.Config{})
+	replicaConn := &MockConn{}
+	handle(handler, replicaConn, protocol.Command{Name: "PSYNC", Args: []string{"?", "-1"}})
+
+	conn := &MockConn{}
+	_, err := handle(handler, conn, protocol.Command{
+		Name: "WAIT",
+		Args: []string{"1", "0"},
+	})
+
+	require.NoError(t, err, "Expected no error when handling WAIT command")
+	require.Len(t, conn.writes, 1, "Expected one write to the connection")
+	assert.Equal(t, ":1\r\n", string(conn.writes[0]), "Expected replica without pending writes to count")
+	assert.Len(t, replicaConn.writes, 1, "Expected no GETACK when nothing is pending")
+}
+
+func TestHandleWaitTimeout(t *testing.T) {
+	handler := NewCommandHandler(&config.Config{})
+	replicaConn := &MockConn{}
+	handle(handler, replicaConn, protocol.Command{Name: "PSYNC", Args: []string{"?", "-1"}})
+	conn := &MockConn{}
+	handle(handler, conn, protocol.Command{Name: "SET", Args: []string{"key", "value"}})
+
+	_, err := handle(handler, conn, protocol.Command{
+		Name: "WAIT",
+		Args: []string{"1", "10"},
+	})
+
+	require.NoError(t, err, "Expected no error when handling WAIT command")
 	require.Len(t, conn.writes, 2, "Expected two writes to the connection")
+	assert.Equal(t, ":0\r\n", string(conn.writes[1]), "Expected no replica to acknowledge the write")
+	require.Len(t, replicaConn.writes, 3, "Expected SET and GETACK to be propagated")
 	assert.Equal(t,
-		string([]byte("+FULLRESYNC test 0\r\n")),
+		"*3\r\n$8\r\nREPLCONF\r\n$6\r\nGETACK\r\n$1\r\n*\r\n",
+		string(replicaConn.writes[2]),
+		"Expected WAIT to request acknowledgements from replicas",
+	)
+}
+
+func TestHandlePSyncPartialResync(t *testing.T) {
+	master := replication.NewMaster()
+	handler := NewCommandHandler(&config.Config{}, WithReplication(master))
+	conn := &MockConn{}
+	handle(handler, conn, protocol.Command{Name: "SET", Args: []string{"key", "value"}})
+	handle(handler, conn, protocol.Command{Name: "SET", Args: []string{"key2", "value2"}})
+
+	// The replica processed the first SET (33 bytes) and asks for the next byte.
+	replicaConn := &MockConn{}
+	_, err := handle(handler, replicaConn, protocol.Command{
+		Name: "PSYNC",
+		Args: []string{master.ReplID(), "34"},
+	})
+
+	require.NoError(t, err, "Expected no error when handling PSYNC command")
+	require.Len(t, replicaConn.writes, 1, "Expected CONTINUE and the missing backlog")
+	assert.Equal(t,
+		"+CONTINUE "+master.ReplID()+"\r\n*3\r\n$3\r\nSET\r\n$4\r\nkey2\r\n$6\r\nvalue2\r\n",
+		string(replicaConn.writes[0]),
+		"Expected only the missing write to be sent",
+	)
+}
+
+func TestHandlePSyncUnknownReplIDFallsBackToFullResync(t *testing.T) {
+	handler := NewCommandHandler(&config.Config{})
+	conn := &MockConn{}
+	_, err := handle(handler, conn, protocol.Command{
+		Name: "PSYNC",
+		Args: []string{"0000000000000000000000000000000000000000", "1"},
+	})
+
+	require.NoError(t, err, "Expected no error when handling PSYNC command")
+	require.Len(t, conn.writes, 1, "Expected FULLRESYNC and the DB file")
+	assert.Regexp(t, "^\\+FULLRESYNC [0-9a-f]{40} 0\r\n\\$", string(conn.writes[0]))
+}
+
+func TestHandleInfoReplicationOnReplica(t *testing.T) {
+	link := replication.NewLink(&config.Node{Host: "localhost", Port: 6380})
+	link.SetState(replication.LinkSync)
+	handler := NewCommandHandler(&config.Config{}, WithLink(link))
+	conn := &MockConn{}
+
+	_, err := handle(handler, conn, protocol.Command{
+		Name: "INFO",
+		Args: []string{"replication"},
+	})
+
+	require.NoError(t, err, "Expected no error when handling INFO command")
+	require.Len(t, conn.writes, 1, "Expected one write to the connection")
+	info := string(conn.writes[0])
+	assert.Contains(t, info, "role:slave\r\n")
+	assert.Contains(t, info, "master_host:localhost\r\n")
+	assert.Contains(t, info, "master_port:6380\r\n")
+	assert.Contains(t, info, "master_link_status:down\r\n")
+	assert.Contains(t, info, "master_link_state:sync\r\n")
+	assert.Contains(t, info, "master_sync_in_progress:1\r\n")
+}
+
+type mockRoleSwitcher struct {
+	calls []*config.Node
+}
+
+func (m *mockRoleSwitcher) ReplicaOf(_ context.Context, master *config.Node) error {
+	m.calls = append(m.calls, master)
+	return nil
+}
+
+func TestHandleReplicaOf(t *testing.T) {
+	switcher := &mockRoleSwitcher{}
+	handler := NewCommandHandler(&config.Config{}, WithRoleSwitcher(switcher))
+	conn := &MockConn{}
+
+	_, err := handle(handler, conn, protocol.Command{
+		Name: "REPLICAOF",
+		Args: []string{"localhost", "6380"},
+	})
+	require.NoError(t, err, "Expected no error when handling REPLICAOF command")
+	_, err = handle(handler, conn, protocol.Command{
+		Name: "SLAVEOF",
+		Args: []string{"no", "one"},
+	})
+	require.NoError(t, err, "Expected no error when handling SLAVEOF command")
+
+	require.Len(t, conn.writes, 2, "Expected two writes to the connection")
+	assert.Equal(t, "+OK\r\n", string(conn.writes[0]))
+	assert.Equal(t, "+OK\r\n", string(conn.writes[1]))
+	require.Len(t, switcher.calls, 2, "Expected the role to be switched twice")
+	assert.Equal(t, &config.Node{Host: "localhost", Port: 6380}, switcher.calls[0])
+	assert.Nil(t, switcher.calls[1], "Expected NO ONE to promote the server to master")
+}
+
+func TestHandleRoleOnMaster(t *testing.T) {
+	handler := NewCommandHandler(&config.Config{})
+	replicaConn := &MockConn{}
+	handle(handler, replicaConn, protocol.Command{Name: "REPLCONF", Args: []string{"listening-port", "6380"}})
+	handle(handler, replicaConn, protocol.Command{Name: "PSYNC", Args: []string{"?", "-1"}})
+	handle(handler, replicaConn, protocol.Command{Name: "REPLCONF", Args: []string{"ACK", "0"}})
+	conn := &MockConn{}
+
+	_, err := handle(handler, conn, protocol.Command{Name: "ROLE"})
+
+	require.NoError(t, err, "Expected no error when handling ROLE command")
+	require.Len(t, conn.writes, 1, "Expected one write to the connection")
+	assert.Equal(t,
+		"*3\r\n$6\r\nmaster\r\n:0\r\n*1\r\n*3\r\n$0\r\n\r\n$4\r\n6380\r\n$1\r\n0\r\n",
+		string(conn.writes[0]),
+		"Expected ROLE to list the replica with its listening port and ack offset",
+	)
+}
+
+func TestHandleRoleReportsAnnouncedIP(t *testing.T) {
+	handler := NewCommandHandler(&config.Config{})
+	replicaConn := &MockConn{}
+	handle(handler, replicaConn, protocol.Command{
+		Name: "REPLCONF", Args: []string{"listening-port", "6380", "ip-address", "10.0.0.1"},
+	})
+	handle(handler, replicaConn, protocol.Command{Name: "PSYNC", Args: []string{"?", "-1"}})
+	conn := &MockConn{}
+
+	_, err := handle(handler, conn, protocol.Command{Name: "ROLE"})
+
+	require.NoError(t, err, "Expected no error when handling ROLE command")
+	require.Len(t, conn.writes, 1, "Expected one write to the connection")
+	assert.Contains(t, string(conn.writes[0]), "$8\r\n10.0.0.1\r\n$4\r\n6380\r\n",
+		"Expected ROLE to list the replica with the address it announced")
+}
+
+func TestHandleRoleOnReplica(t *testing.T) {
+	link := replication.NewLink(&config.Node{Host: "localhost", Port: 6380})
+	link.SetState(replication.LinkConnected)
+	handler := NewCommandHandler(&config.Config{}, WithLink(link))
+	conn := &MockConn{}
+
+	_, err := handle(handler, conn, protocol.Command{Name: "ROLE"})
+
+	require.NoError(t, err, "Expected no error when handling ROLE command")
+	require.Len(t, conn.writes, 1, "Expected one write to the connection")
+	assert.Equal(t,
+		"*5\r\n$5\r\nslave\r\n$9\r\nlocalhost\r\n:6380\r\n$9\r\nconnected\r\n:0\r\n",
 		string(conn.writes[0]),
-		"Expected PSYNC command to return FULLRESYNC response for master",
+		"Expected ROLE to describe the followed master",
 	)
-	assert.NotNil(t, conn.writes[1], "Expected second write to contain DB file content")
+}
+
+func TestHandleHello(t *testing.T) {
+	tests := []struct {
+		name            string
+		args            []string
+		expectedPrefix  string
+		expectedVersion int
+		expectedName    string
+	}{
+		{
+			name:            "Without arguments keeps RESP2",
+			args:            []string{},
+			expectedPrefix:  "*14\r\n$6\r\nserver\r\n$5\r\nredis\r\n",
+			expectedVersion: protocol.RESP2,
+		},
+		{
+			name:            "Switch to RESP3",
+			args:            []string{"3"},
+			expectedPrefix:  "%7\r\n$6\r\nserver\r\n$5\r\nredis\r\n",
+			expectedVersion: protocol.RESP3,
+		},
+		{
+			name:            "Switch to RESP3 with AUTH and SETNAME",
+			args:            []string{"3", "auth", "default", "secret", "setname", "worker"},
+			expectedPrefix:  "%7\r\n",
+			expectedVersion: protocol.RESP3,
+			expectedName:    "worker",
+		},
+		{
+			name:            "Unsupported version",
+			args:            []string{"4"},
+			expectedPrefix:  "-NOPROTO unsupported protocol version\r\n",
+			expectedVersion: protocol.RESP2,
+		},
+		{
+			name:            "Unknown user",
+			args:            []string{"3", "AUTH", "admin", "secret"},
+			expectedPrefix:  "-WRONGPASS ",
+			expectedVersion: protocol.RESP2,
+		},
+		{
+			name:            "Unknown option",
+			args:            []string{"3", "SETNAME"},
+			expectedPrefix:  "-ERR Syntax error in HELLO option 'SETNAME'\r\n",
+			expectedVersion: protocol.RESP2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := &MockConn{}
+			handler := NewCommandHandler(&config.Config{})
+			_, err := handle(handler, conn, protocol.NewCommand("HELLO", tt.args))
+
+			require.NoError(t, err)
+			require.Len(t, conn.writes, 1)
+			assert.True(t, strings.HasPrefix(string(conn.writes[0]), tt.expectedPrefix), "unexpected reply: %q", conn.writes[0])
+			assert.Equal(t, tt.expectedVersion, conn.Client().Version())
+			assert.Equal(t, tt.expectedName, conn.Client().Name())
+		})
+	}
+}
+
+func TestHandleGetMissingKeyWithRESP3(t *testing.T) {
+	conn := &MockConn{}
+	conn.Client().SetVersion(protocol.RESP3)
+	handler := NewCommandHandler(&config.Config{})
+
+	_, err := handle(handler, conn, protocol.NewCommand("GET", []string{"missing"}))
+
+	require.NoError(t, err)
+	require.Len(t, conn.writes, 1)
+	assert.Equal(t, "_\r\n", string(conn.writes[0]))
+}
+
+func TestHandleErrorReplies(t *testing.T) {
+	tests := []struct {
+		name          string
+		command       protocol.Command
+		expectedReply string
+	}{
+		{
+			name:          "Unknown command",
+			command:       protocol.NewCommand("FOO", []string{"a", "b"}),
+			expectedReply: "-ERR unknown command 'FOO', with args beginning with: 'a' 'b' \r\n",
+		},
+		{
+			name:          "Wrong number of arguments",
+			command:       protocol.NewCommand("SET", []string{"key"}),
+			expectedReply: "-ERR wrong number of arguments for 'set' command\r\n",
+		},
+		{
+			name:          "DEL without keys",
+			command:       protocol.NewCommand("DEL", nil),
+			expectedReply: "-ERR wrong number of arguments for 'del' command\r\n",
+		},
+		{
+			name:          "Unsupported SET option",
+			command:       protocol.NewCommand("SET", []string{"key", "value", "KEEPTTL?"}),
+			expectedReply: "-ERR syntax error\r\n",
+		},
+		{
+			name:          "Non integer PX",
+			command:       protocol.NewCommand("SET", []string{"key", "value", "PX", "soon"}),
+			expectedReply: "-ERR value is not an integer or out of range\r\n",
+		},
+		{
+			name:          "Non positive PX",
+			command:       protocol.NewCommand("SET", []string{"key", "value", "PX", "0"}),
+			expectedReply: "-ERR invalid expire time in 'set' command\r\n",
+		},
+		{
+			name:          "Negative WAIT timeout",
+			command:       protocol.NewCommand("WAIT", []string{"1", "-1"}),
+			expectedReply: "-ERR timeout is negative\r\n",
+		},
+		{
+			name:          "Unsupported protocol version",
+			command:       protocol.NewCommand("HELLO", []string{"4"}),
+			expectedReply: "-NOPROTO unsupported protocol version\r\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := &MockConn{}
+			handler := NewCommandHandler(&config.Config{})
+			result, err := handle(handler, conn, tt.command)
+
+			require.NoError(t, err)
+			require.Len(t, conn.writes, 1)
+			assert.Equal(t, tt.expectedReply, string(conn.writes[0]))
+			var replyErr protocol.ErrorReply
+			require.ErrorAs(t, result.CommandError, &replyErr)
+			assert.Equal(t, strings.TrimSuffix(tt.expectedReply[1:], "\r\n"), replyErr.Error())
+		})
+	}
 }
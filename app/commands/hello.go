@@ -0,0 +1,85 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/jorzel/myredis/app/client"
+	"github.com/jorzel/myredis/app/config"
+	"github.com/jorzel/myredis/app/protocol"
+)
+
+func (h *DefaultCommandHandler) handleHello(
+	ctx context.Context, c *client.Client, command protocol.Command,
+) (HandleResult, error) {
+	msg, commandErr := h.executeHello(ctx, c, command)
+	err := h.sendMsg(ctx, c, msg)
+	return HandleResult{
+		CommandError: commandErr,
+	}, err
+}
+
+// executeHello switches the protocol version of the connection and replies
+// with the server info, in the newly negotiated version.
+func (h *DefaultCommandHandler) executeHello(
+	_ context.Context, c *client.Client, command protocol.Command,
+) (protocol.Reply, error) {
+	version := c.Version()
+	var name, user *string
+	if len(command.Args) > 0 {
+		requested, err := strconv.Atoi(command.Args[0])
+		if err != nil {
+			err := protocol.NewError("Protocol version is not an integer or out of range")
+			return err, err
+		}
+		if requested != protocol.RESP2 && requested != protocol.RESP3 {
+			return protocol.ErrNoProto, protocol.ErrNoProto
+		}
+		version = requested
+
+		for i := 1; i < len(command.Args); i++ {
+			option := strings.ToUpper(command.Args[i])
+			switch {
+			case option == "AUTH" && i+2 < len(command.Args):
+				if command.Args[i+1] != client.DefaultUser {
+					return protocol.ErrWrongPass, protocol.ErrWrongPass
+				}
+				user = &command.Args[i+1]
+				i += 2
+			case option == "SETNAME" && i+1 < len(command.Args):
+				if !validClientName(command.Args[i+1]) {
+					return errInvalidClientName, errInvalidClientName
+				}
+				name = &command.Args[i+1]
+				i++
+			default:
+				err := protocol.NewError(fmt.Sprintf("Syntax error in HELLO option '%s'", command.Args[i]))
+				return err, err
+			}
+		}
+	}
+
+	c.SetVersion(version)
+	if user != nil {
+		c.SetUser(*user)
+	}
+	if name != nil {
+		c.SetName(*name)
+	}
+
+	role := config.MasterRole
+	if h.isReplica() {
+		role = config.ReplicaRole
+	}
+	return protocol.MapReply{
+		{Key: protocol.BulkStringReply("server"), Value: protocol.BulkStringReply("redis")},
+		{Key: protocol.BulkStringReply("version"), Value: protocol.BulkStringReply(config.RedisVersion)},
+		{Key: protocol.BulkStringReply("proto"), Value: protocol.IntegerReply(version)},
+		{Key: protocol.BulkStringReply("id"), Value: protocol.IntegerReply(c.ID())},
+		{Key: protocol.BulkStringReply("mode"), Value: protocol.BulkStringReply("standalone")},
+		{Key: protocol.BulkStringReply("role"), Value: protocol.BulkStringReply(role)},
+		{Key: protocol.BulkStringReply("modules"), Value: protocol.ArrayReply{}},
+	}, nil
+}
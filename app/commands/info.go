@@ -0,0 +1,188 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jorzel/myredis/app/client"
+	"github.com/jorzel/myredis/app/protocol"
+	"github.com/jorzel/myredis/app/replication"
+)
+
+// infoSection renders the fields of a single INFO section.
+type infoSection struct {
+	name   string
+	render func(h *DefaultCommandHandler) [][2]string
+}
+
+var infoSections = []infoSection{
+	{name: "persistence", render: (*DefaultCommandHandler).persistenceInfo},
+	{name: "stats", render: (*DefaultCommandHandler).statsInfo},
+	{name: "replication", render: (*DefaultCommandHandler).replicationInfo},
+}
+
+func (h *DefaultCommandHandler) handleInfo(
+	ctx context.Context, c *client.Client, command protocol.Command,
+) (HandleResult, error) {
+	msg, commandErr := h.executeInfo(ctx, command)
+	err := h.sendMsg(ctx, c, msg)
+	return HandleResult{
+		CommandError: commandErr,
+	}, err
+}
+
+func (h *DefaultCommandHandler) executeInfo(_ context.Context, command protocol.Command) (protocol.Reply, error) {
+	requested := map[string]bool{}
+	for _, arg := range command.Args {
+		requested[strings.ToLower(arg)] = true
+	}
+	all := len(requested) == 0 || requested["all"] || requested["default"] || requested["everything"]
+
+	var sb strings.Builder
+	for _, section := range infoSections {
+		if !all && !requested[section.name] {
+			continue
+		}
+		if sb.Len() > 0 {
+			sb.WriteString(protocol.CRLF)
+		}
+		fmt.Fprintf(&sb, "# %s%s", strings.ToUpper(section.name[:1])+section.name[1:], protocol.CRLF)
+		for _, field := range section.render(h) {
+			fmt.Fprintf(&sb, "%s:%s%s", field[0], field[1], protocol.CRLF)
+		}
+	}
+	return protocol.VerbatimReply{Format: "txt", Text: sb.String()}, nil
+}
+
+func (h *DefaultCommandHandler) persistenceInfo() [][2]string {
+	var changes int64
+	bgsaveInProgress, lastSave := "0", time.Now()
+	bgsaveStatus, currentBgsave, lastBgsave := "ok", time.Duration(-1), time.Duration(-1)
+	if h.rdb != nil {
+		changes, lastSave = h.rdb.Changes(), h.rdb.LastSave()
+		if h.rdb.InProgress() {
+			bgsaveInProgress = "1"
+		}
+		var err error
+		currentBgsave, lastBgsave, err = h.rdb.BackgroundSaveStatus()
+		if err != nil {
+			bgsaveStatus = "err"
+		}
+	}
+	fields := [][2]string{
+		{"loading", "0"},
+		{"rdb_changes_since_last_save", fmt.Sprint(changes)},
+		{"rdb_bgsave_in_progress", bgsaveInProgress},
+		{"rdb_last_save_time", fmt.Sprint(lastSave.Unix())},
+		{"rdb_last_bgsave_status", bgsaveStatus},
+		{"rdb_last_bgsave_time_sec", fmt.Sprint(seconds(lastBgsave))},
+		{"rdb_current_bgsave_time_sec", fmt.Sprint(seconds(currentBgsave))},
+	}
+
+	if h.aof == nil {
+		return append(fields, [][2]string{
+			{"aof_enabled", "0"},
+			{"aof_rewrite_in_progress", "0"},
+			{"aof_last_bgrewrite_status", "ok"},
+			{"aof_last_write_status", "ok"},
+		}...)
+	}
+	rewriteInProgress, rewriteStatus := "0", "ok"
+	if h.aof.RewriteInProgress() {
+		rewriteInProgress = "1"
+	}
+	if h.aof.LastRewriteErr() != nil {
+		rewriteStatus = "err"
+	}
+	writeStatus := "ok"
+	if h.aof.LastWriteErr() != nil {
+		writeStatus = "err"
+	}
+	currentSize, baseSize := h.aof.Size()
+	return append(fields, [][2]string{
+		{"aof_enabled", "1"},
+		{"aof_rewrite_in_progress", rewriteInProgress},
+		{"aof_last_bgrewrite_status", rewriteStatus},
+		{"aof_last_write_status", writeStatus},
+		{"aof_current_size", fmt.Sprint(currentSize)},
+		{"aof_base_size", fmt.Sprint(baseSize)},
+	}...)
+}
+
+// seconds rounds d down to whole seconds, keeping -1 for durations reported
+// as unknown.
+func seconds(d time.Duration) int64 {
+	if d < 0 {
+		return -1
+	}
+	return int64(d / time.Second)
+}
+
+func (h *DefaultCommandHandler) statsInfo() [][2]string {
+	return [][2]string{
+		{"total_commands_processed", fmt.Sprint(h.stats.commands.Load())},
+		{"total_error_replies", fmt.Sprint(h.stats.errorReplies.Load())},
+	}
+}
+
+func (h *DefaultCommandHandler) replicationInfo() [][2]string {
+	var fields [][2]string
+	if master := h.link.Master(); master != nil {
+		state := h.link.State()
+		linkStatus, syncInProgress := "down", "0"
+		switch state {
+		case replication.LinkConnected:
+			linkStatus = "up"
+		case replication.LinkSync:
+			syncInProgress = "1"
+		}
+		readOnly := "0"
+		if h.config.IsReplicaReadOnly() {
+			readOnly = "1"
+		}
+		fields = [][2]string{
+			{"role", "slave"},
+			{"master_host", master.Host},
+			{"master_port", fmt.Sprint(master.Port)},
+			{"master_link_status", linkStatus},
+			{"master_link_state", state},
+			{"master_sync_in_progress", syncInProgress},
+			{"slave_repl_offset", fmt.Sprint(h.replication.Offset())},
+			{"slave_read_only", readOnly},
+		}
+	} else {
+		fields = [][2]string{{"role", "master"}}
+	}
+
+	replicas := h.replication.Replicas()
+	fields = append(fields, [2]string{"connected_slaves", fmt.Sprint(len(replicas))})
+	for i, replica := range replicas {
+		fields = append(fields, [2]string{
+			fmt.Sprintf("slave%d", i),
+			fmt.Sprintf("ip=%s,port=%d,state=online,offset=%d,lag=%d",
+				replica.IP(), replica.ListeningPort(), replica.AckOffset(), int(replica.Lag().Seconds())),
+		})
+	}
+
+	replID2, replID2Offset := h.replication.ReplID2()
+	secondOffset := int64(-1)
+	if replID2 == "" {
+		replID2 = strings.Repeat("0", 40)
+	} else {
+		secondOffset = replID2Offset + 1
+	}
+	backlogStart, backlogLen, backlogSize := h.replication.BacklogInfo()
+	return append(fields, [][2]string{
+		{"master_replid", h.replication.ReplID()},
+		{"master_replid2", replID2},
+		{"master_repl_offset", fmt.Sprint(h.replication.Offset())},
+		{"second_repl_offset", fmt.Sprint(secondOffset)},
+		{"repl_backlog_active", "1"},
+		{"repl_backlog_size", fmt.Sprint(backlogSize)},
+		// Replication offsets of the backlog are reported starting from 1.
+		{"repl_backlog_first_byte_offset", fmt.Sprint(backlogStart + 1)},
+		{"repl_backlog_histlen", fmt.Sprint(backlogLen)},
+	}...)
+}
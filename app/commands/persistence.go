@@ -0,0 +1,131 @@
+package commands
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jorzel/myredis/app/client"
+	"github.com/jorzel/myredis/app/persistence"
+	"github.com/jorzel/myredis/app/protocol"
+	"github.com/jorzel/myredis/app/rdb"
+	"github.com/rs/zerolog"
+)
+
+var (
+	errBackgroundSaveInProgress    = protocol.NewError("Background save already in progress")
+	errBackgroundRewriteInProgress = protocol.NewError("Background append only file rewriting already in progress")
+)
+
+func (h *DefaultCommandHandler) handleSave(
+	ctx context.Context, c *client.Client, command protocol.Command,
+) (HandleResult, error) {
+	msg, commandErr := h.executeSave(ctx, command)
+	err := h.sendMsg(ctx, c, msg)
+	return HandleResult{
+		CommandError: commandErr,
+	}, err
+}
+
+func (h *DefaultCommandHandler) executeSave(ctx context.Context, command protocol.Command) (protocol.Reply, error) {
+	logger := zerolog.Ctx(ctx)
+	if h.rdb == nil {
+		err := protocol.NewError(command.Name + " is not supported by this server")
+		return err, err
+	}
+	if h.rdb.InProgress() {
+		return errBackgroundSaveInProgress, errBackgroundSaveInProgress
+	}
+	// Writes wait for the save, so the snapshot is consistent and every
+	// change it counts is in it.
+	h.writeMu.Lock()
+	defer h.writeMu.Unlock()
+	if err := h.rdb.Save(rdb.Collect(h.storage)); err != nil {
+		logger.Err(err).Msg("Failed to save the RDB file")
+		replyErr := protocol.NewError("Failed to save the RDB file: " + err.Error())
+		return replyErr, replyErr
+	}
+	return protocol.OK, nil
+}
+
+func (h *DefaultCommandHandler) handleBgsave(
+	ctx context.Context, c *client.Client, command protocol.Command,
+) (HandleResult, error) {
+	msg, commandErr := h.executeBgsave(ctx, command)
+	err := h.sendMsg(ctx, c, msg)
+	return HandleResult{
+		CommandError: commandErr,
+	}, err
+}
+
+func (h *DefaultCommandHandler) executeBgsave(ctx context.Context, command protocol.Command) (protocol.Reply, error) {
+	if h.rdb == nil {
+		err := protocol.NewError(command.Name + " is not supported by this server")
+		return err, err
+	}
+	if len(command.Args) > 0 {
+		return protocol.ErrSyntax, protocol.ErrSyntax
+	}
+	// No write runs between collecting the data set and counting the
+	// changes it includes.
+	h.writeMu.Lock()
+	defer h.writeMu.Unlock()
+	// The save outlives the command, so it must not be cancelled with it.
+	err := h.rdb.BackgroundSave(context.WithoutCancel(ctx), rdb.Collect(h.storage))
+	if errors.Is(err, persistence.ErrSaveInProgress) {
+		return errBackgroundSaveInProgress, errBackgroundSaveInProgress
+	}
+	return protocol.SimpleStringReply("Background saving started"), nil
+}
+
+func (h *DefaultCommandHandler) handleLastsave(
+	ctx context.Context, c *client.Client, command protocol.Command,
+) (HandleResult, error) {
+	msg, commandErr := h.executeLastsave(ctx, command)
+	err := h.sendMsg(ctx, c, msg)
+	return HandleResult{
+		CommandError: commandErr,
+	}, err
+}
+
+func (h *DefaultCommandHandler) executeLastsave(_ context.Context, command protocol.Command) (protocol.Reply, error) {
+	if h.rdb == nil {
+		err := protocol.NewError(command.Name + " is not supported by this server")
+		return err, err
+	}
+	return protocol.IntegerReply(h.rdb.LastSave().Unix()), nil
+}
+
+func (h *DefaultCommandHandler) handleBgrewriteaof(
+	ctx context.Context, c *client.Client, command protocol.Command,
+) (HandleResult, error) {
+	msg, commandErr := h.executeBgrewriteaof(ctx, command)
+	err := h.sendMsg(ctx, c, msg)
+	return HandleResult{
+		CommandError: commandErr,
+	}, err
+}
+
+func (h *DefaultCommandHandler) executeBgrewriteaof(ctx context.Context, command protocol.Command) (protocol.Reply, error) {
+	if h.aof == nil {
+		err := protocol.NewError(command.Name + " is not supported by this server, AOF is turned off")
+		return err, err
+	}
+	h.writeMu.Lock()
+	defer h.writeMu.Unlock()
+	if err := h.rewriteAOF(ctx); errors.Is(err, persistence.ErrRewriteInProgress) {
+		return errBackgroundRewriteInProgress, errBackgroundRewriteInProgress
+	}
+	return protocol.SimpleStringReply("Background append only file rewriting started"), nil
+}
+
+// rewriteAOF starts a background rewrite of the AOF file. The caller must
+// hold writeMu, so no write lands between collecting the data set and
+// buffering the writes that follow.
+func (h *DefaultCommandHandler) rewriteAOF(ctx context.Context) error {
+	// The rewrite outlives the command, so it must not be cancelled with it.
+	err := h.aof.BackgroundRewrite(context.WithoutCancel(ctx), rdb.Collect(h.storage))
+	if err == nil {
+		zerolog.Ctx(ctx).Info().Msg("Background append only file rewriting started")
+	}
+	return err
+}
@@ -0,0 +1,188 @@
+package commands
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/jorzel/myredis/app/config"
+	"github.com/jorzel/myredis/app/persistence"
+	"github.com/jorzel/myredis/app/protocol"
+	"github.com/jorzel/myredis/app/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleSave(t *testing.T) {
+	r := persistence.NewRDB(filepath.Join(t.TempDir(), "dump.rdb"))
+	handler := NewCommandHandler(&config.Config{}, WithRDB(r))
+	conn := &MockConn{}
+	handle(handler, conn, protocol.NewCommand("SET", []string{"key", "value"}))
+
+	_, err := handle(handler, conn, protocol.NewCommand("SAVE", nil))
+
+	require.NoError(t, err)
+	require.Len(t, conn.writes, 2)
+	assert.Equal(t, "+OK\r\n", string(conn.writes[1]))
+	loaded := storage.NewStorage()
+	found, err := r.Load(loaded)
+	require.NoError(t, err)
+	require.True(t, found)
+	record, err := loaded.Get("key")
+	require.NoError(t, err)
+	require.NotNil(t, record)
+	assert.Equal(t, "value", record.Value)
+}
+
+func TestHandleBgsave(t *testing.T) {
+	r := persistence.NewRDB(filepath.Join(t.TempDir(), "dump.rdb"))
+	handler := NewCommandHandler(&config.Config{}, WithRDB(r))
+	conn := &MockConn{}
+
+	_, err := handle(handler, conn, protocol.NewCommand("BGSAVE", nil))
+
+	require.NoError(t, err)
+	require.Len(t, conn.writes, 1)
+	assert.Equal(t, "+Background saving started\r\n", string(conn.writes[0]))
+	require.Eventually(t, func() bool { return !r.InProgress() }, time.Second, time.Millisecond)
+	_, err = os.Stat(r.Path())
+	assert.NoError(t, err)
+}
+
+func TestHandleLastsave(t *testing.T) {
+	r := persistence.NewRDB(filepath.Join(t.TempDir(), "dump.rdb"))
+	handler := NewCommandHandler(&config.Config{}, WithRDB(r))
+	conn := &MockConn{}
+
+	_, err := handle(handler, conn, protocol.NewCommand("LASTSAVE", nil))
+
+	require.NoError(t, err)
+	require.Len(t, conn.writes, 1)
+	assert.Equal(t, ":"+strconv.FormatInt(r.LastSave().Unix(), 10)+"\r\n", string(conn.writes[0]))
+}
+
+func TestHandleSaveWithoutRDB(t *testing.T) {
+	handler := NewCommandHandler(&config.Config{})
+	conn := &MockConn{}
+
+	_, err := handle(handler, conn, protocol.NewCommand("SAVE", nil))
+
+	require.NoError(t, err)
+	require.Len(t, conn.writes, 1)
+	assert.Equal(t, "-ERR SAVE is not supported by this server\r\n", string(conn.writes[0]))
+}
+
+func TestHandleLogsWritesToAOF(t *testing.T) {
+	a := persistence.NewAOF(filepath.Join(t.TempDir(), "appendonly.aof"), config.AppendFsyncAlways)
+	require.NoError(t, a.Open(context.Background()))
+	handler := NewCommandHandler(&config.Config{}, WithAOF(a))
+	conn := &MockConn{}
+	before := time.Now()
+
+	handle(handler, conn, protocol.NewCommand("SET", []string{"key", "value", "PX", "60000"}))
+	handle(handler, conn, protocol.NewCommand("GET", []string{"key"}))
+	handle(handler, conn, protocol.NewCommand("SET", []string{"key", "value", "PX", "nope"}))
+	handle(handler, conn, protocol.NewCommand("DEL", []string{"key"}))
+	require.NoError(t, a.Close())
+
+	var logged []protocol.Command
+	_, err := a.Load(context.Background(), func(command protocol.Command) error {
+		logged = append(logged, command)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Len(t, logged, 2, "Expected only successful writes to be logged")
+	assert.Equal(t, "DEL", logged[1].Name)
+	require.Equal(t, []string{"key", "value", "PXAT"}, logged[0].Args[:3], "Expected the expiry to be logged as an absolute one")
+	expireAt, err := strconv.ParseInt(logged[0].Args[3], 10, 64)
+	require.NoError(t, err)
+	assert.InDelta(t, before.Add(time.Minute).UnixMilli(), expireAt, 1000)
+}
+
+func TestHandleBgrewriteaof(t *testing.T) {
+	a := persistence.NewAOF(filepath.Join(t.TempDir(), "appendonly.aof"), config.AppendFsyncNo)
+	require.NoError(t, a.Open(context.Background()))
+	handler := NewCommandHandler(&config.Config{}, WithAOF(a))
+	conn := &MockConn{}
+	handle(handler, conn, protocol.NewCommand("SET", []string{"key", "1"}))
+	handle(handler, conn, protocol.NewCommand("SET", []string{"key", "2"}))
+
+	_, err := handle(handler, conn, protocol.NewCommand("BGREWRITEAOF", nil))
+
+	require.NoError(t, err)
+	require.Len(t, conn.writes, 3)
+	assert.Equal(t, "+Background append only file rewriting started\r\n", string(conn.writes[2]))
+	require.Eventually(t, func() bool { return !a.RewriteInProgress() }, time.Second, time.Millisecond)
+	require.NoError(t, a.Close())
+	var logged []protocol.Command
+	_, err = a.Load(context.Background(), func(command protocol.Command) error {
+		logged = append(logged, command)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []protocol.Command{protocol.NewCommand("SET", []string{"key", "2"})}, logged)
+}
+
+func TestHandleBgrewriteaofWithoutAOF(t *testing.T) {
+	handler := NewCommandHandler(&config.Config{})
+	conn := &MockConn{}
+
+	_, err := handle(handler, conn, protocol.NewCommand("BGREWRITEAOF", nil))
+
+	require.NoError(t, err)
+	require.Len(t, conn.writes, 1)
+	assert.Equal(t, "-ERR BGREWRITEAOF is not supported by this server, AOF is turned off\r\n", string(conn.writes[0]))
+}
+
+func TestHandleRewritesAOFAutomatically(t *testing.T) {
+	a := persistence.NewAOF(filepath.Join(t.TempDir(), "appendonly.aof"), config.AppendFsyncNo)
+	require.NoError(t, a.Open(context.Background()))
+	// Big enough to hold both commands, so the rewrite starts after the second.
+	a.SetAutoRewrite(100, int64(2*len(protocol.BulkArray([]string{"SET", "key", "1"}))))
+	handler := NewCommandHandler(&config.Config{}, WithAOF(a))
+	conn := &MockConn{}
+
+	handle(handler, conn, protocol.NewCommand("SET", []string{"key", "1"}))
+	handle(handler, conn, protocol.NewCommand("SET", []string{"key", "2"}))
+
+	require.Eventually(t, func() bool { return !a.RewriteInProgress() }, time.Second, time.Millisecond)
+	require.NoError(t, a.Close())
+	var logged []protocol.Command
+	_, err := a.Load(context.Background(), func(command protocol.Command) error {
+		logged = append(logged, command)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []protocol.Command{protocol.NewCommand("SET", []string{"key", "2"})}, logged)
+}
+
+func TestHandleInfoPersistence(t *testing.T) {
+	r := persistence.NewRDB(filepath.Join(t.TempDir(), "dump.rdb"))
+	handler := NewCommandHandler(&config.Config{}, WithRDB(r))
+	conn := &MockConn{}
+	handle(handler, conn, protocol.NewCommand("SET", []string{"key", "1"}))
+	handle(handler, conn, protocol.NewCommand("SET", []string{"key", "2"}))
+	handle(handler, conn, protocol.NewCommand("GET", []string{"key"}))
+
+	_, err := handle(handler, conn, protocol.NewCommand("INFO", []string{"persistence"}))
+
+	require.NoError(t, err)
+	require.Len(t, conn.writes, 4)
+	info := string(conn.writes[3])
+	assert.Contains(t, info, "# Persistence\r\n")
+	assert.Contains(t, info, "rdb_changes_since_last_save:2\r\n")
+	assert.Contains(t, info, "rdb_bgsave_in_progress:0\r\n")
+	assert.Contains(t, info, "rdb_last_bgsave_status:ok\r\n")
+	assert.Contains(t, info, "rdb_last_bgsave_time_sec:-1\r\n")
+	assert.Contains(t, info, "aof_enabled:0\r\n")
+	assert.NotContains(t, info, "# Replication")
+
+	handle(handler, conn, protocol.NewCommand("SAVE", nil))
+	handle(handler, conn, protocol.NewCommand("INFO", []string{"persistence"}))
+
+	require.Len(t, conn.writes, 6)
+	assert.Contains(t, string(conn.writes[5]), "rdb_changes_since_last_save:0\r\n")
+}
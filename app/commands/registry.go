@@ -0,0 +1,295 @@
+package commands
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/jorzel/myredis/app/client"
+	"github.com/jorzel/myredis/app/protocol"
+)
+
+// Flag describes how a command behaves, as reported by COMMAND INFO.
+type Flag uint
+
+const (
+	// FlagWrite marks commands that modify the data set. They are serialized,
+	// propagated to replicas and rejected by read only replicas.
+	FlagWrite Flag = 1 << iota
+	// FlagReadOnly marks commands that only read the data set.
+	FlagReadOnly
+	// FlagDenyOOM marks commands that may grow memory usage.
+	FlagDenyOOM
+	// FlagAdmin marks administrative commands.
+	FlagAdmin
+	// FlagPubSub marks commands related to pub/sub.
+	FlagPubSub
+	// FlagNoScript marks commands not allowed in scripts.
+	FlagNoScript
+	// FlagLoading marks commands allowed while the data set is loading.
+	FlagLoading
+	// FlagStale marks commands allowed on a replica with a stale data set.
+	FlagStale
+	// FlagFast marks commands that run in constant or logarithmic time.
+	FlagFast
+)
+
+var flagNames = []struct {
+	flag Flag
+	name string
+}{
+	{FlagWrite, "write"},
+	{FlagReadOnly, "readonly"},
+	{FlagDenyOOM, "denyoom"},
+	{FlagAdmin, "admin"},
+	{FlagPubSub, "pubsub"},
+	{FlagNoScript, "noscript"},
+	{FlagLoading, "loading"},
+	{FlagStale, "stale"},
+	{FlagFast, "fast"},
+}
+
+// Names returns the names of the flags set, in Redis order.
+func (f Flag) Names() []string {
+	names := []string{}
+	for _, fn := range flagNames {
+		if f&fn.flag != 0 {
+			names = append(names, fn.name)
+		}
+	}
+	return names
+}
+
+type handleFunc func(h *DefaultCommandHandler, ctx context.Context, c *client.Client, command protocol.Command) (HandleResult, error)
+
+// Spec describes a command supported by the server.
+type Spec struct {
+	// Name is the lowercase command name.
+	Name string
+	// Arity is the number of arguments including the command name. A negative
+	// arity means at least -Arity arguments.
+	Arity int
+	Flags Flag
+	// FirstKey, LastKey and KeyStep locate key arguments, counting the command
+	// name as position 0. A negative LastKey counts from the end, zero
+	// FirstKey means the command takes no keys.
+	FirstKey int
+	LastKey  int
+	KeyStep  int
+	// KeyFlags describe how keys are accessed, such as RW or ACCESS.
+	KeyFlags []string
+
+	// Group, Summary, Since and Complexity document the command for COMMAND DOCS.
+	Group      string
+	Summary    string
+	Since      string
+	Complexity string
+
+	handle handleFunc
+}
+
+// Has reports whether the command has flag set.
+func (s *Spec) Has(flag Flag) bool {
+	return s.Flags&flag != 0
+}
+
+// CheckArity reports whether argc arguments, including the command name,
+// satisfy the arity of the command.
+func (s *Spec) CheckArity(argc int) bool {
+	if s.Arity >= 0 {
+		return argc == s.Arity
+	}
+	return argc >= -s.Arity
+}
+
+// Keys returns the key arguments of command.
+func (s *Spec) Keys(command protocol.Command) []string {
+	if s.FirstKey == 0 {
+		return nil
+	}
+	argv := append([]string{command.Name}, command.Args...)
+	last := s.LastKey
+	if last < 0 {
+		last = len(argv) + last
+	}
+	keys := []string{}
+	for i := s.FirstKey; i <= last && i < len(argv); i += s.KeyStep {
+		keys = append(keys, argv[i])
+	}
+	return keys
+}
+
+// registry maps upper case command names to their specs. It is filled in
+// init, as the handlers of introspection commands read it themselves.
+var registry map[string]*Spec
+
+func init() {
+	registry = map[string]*Spec{}
+	for _, spec := range commandTable() {
+		registry[strings.ToUpper(spec.Name)] = spec
+	}
+}
+
+func commandTable() []*Spec {
+	return []*Spec{
+		{
+			Name: "ping", Arity: -1, Flags: FlagFast,
+			Group: "connection", Summary: "Returns the server's liveliness response.",
+			Since: "1.0.0", Complexity: "O(1)",
+			handle: (*DefaultCommandHandler).handlePing,
+		},
+		{
+			Name: "echo", Arity: 2, Flags: FlagFast,
+			Group: "connection", Summary: "Returns the given string.",
+			Since: "1.0.0", Complexity: "O(1)",
+			handle: (*DefaultCommandHandler).handleEcho,
+		},
+		{
+			Name: "set", Arity: -3, Flags: FlagWrite | FlagDenyOOM,
+			FirstKey: 1, LastKey: 1, KeyStep: 1, KeyFlags: []string{"RW", "ACCESS", "UPDATE"},
+			Group: "string", Summary: "Sets the string value of a key, ignoring its type. The key is created if it doesn't exist.",
+			Since: "1.0.0", Complexity: "O(1)",
+			handle: (*DefaultCommandHandler).handleSet,
+		},
+		{
+			Name: "get", Arity: 2, Flags: FlagReadOnly | FlagFast,
+			FirstKey: 1, LastKey: 1, KeyStep: 1, KeyFlags: []string{"RO", "ACCESS"},
+			Group: "string", Summary: "Returns the string value of a key.",
+			Since: "1.0.0", Complexity: "O(1)",
+			handle: (*DefaultCommandHandler).handleGet,
+		},
+		{
+			Name: "del", Arity: -2, Flags: FlagWrite,
+			FirstKey: 1, LastKey: -1, KeyStep: 1, KeyFlags: []string{"RM", "DELETE"},
+			Group: "generic", Summary: "Deletes one or more keys.",
+			Since: "1.0.0", Complexity: "O(N) where N is the number of keys that will be removed.",
+			handle: (*DefaultCommandHandler).handleDel,
+		},
+		{
+			Name: "replconf", Arity: -1, Flags: FlagAdmin | FlagNoScript | FlagLoading | FlagStale,
+			Group: "server", Summary: "An internal command for configuring the replication stream.",
+			Since: "3.0.0", Complexity: "O(1)",
+			handle: (*DefaultCommandHandler).handleReplConf,
+		},
+		{
+			Name: "psync", Arity: -3, Flags: FlagAdmin | FlagNoScript,
+			Group: "server", Summary: "An internal command used in replication.",
+			Since:  "2.8.0",
+			handle: (*DefaultCommandHandler).handlePsync,
+		},
+		{
+			Name: "wait", Arity: 3, Flags: FlagNoScript,
+			Group: "generic", Summary: "Blocks until the asynchronous replication of all preceding write commands sent by the connection is completed.",
+			Since: "3.0.0", Complexity: "O(1)",
+			handle: (*DefaultCommandHandler).handleWait,
+		},
+		{
+			Name: "info", Arity: -1, Flags: FlagLoading | FlagStale,
+			Group: "server", Summary: "Returns information and statistics about the server.",
+			Since: "1.0.0", Complexity: "O(1)",
+			handle: (*DefaultCommandHandler).handleInfo,
+		},
+		{
+			Name: "replicaof", Arity: 3, Flags: FlagAdmin | FlagNoScript | FlagStale,
+			Group: "server", Summary: "Configures a server as replica of another, or promotes it to a master.",
+			Since: "5.0.0", Complexity: "O(1)",
+			handle: (*DefaultCommandHandler).handleReplicaOf,
+		},
+		{
+			Name: "slaveof", Arity: 3, Flags: FlagAdmin | FlagNoScript | FlagStale,
+			Group: "server", Summary: "Sets a Redis server as a replica of another, or promotes it to being a master.",
+			Since: "1.0.0", Complexity: "O(1)",
+			handle: (*DefaultCommandHandler).handleReplicaOf,
+		},
+		{
+			Name: "role", Arity: 1, Flags: FlagNoScript | FlagLoading | FlagStale | FlagFast,
+			Group: "server", Summary: "Returns the replication role.",
+			Since: "2.8.12", Complexity: "O(1)",
+			handle: (*DefaultCommandHandler).handleRole,
+		},
+		{
+			Name: "hello", Arity: -1, Flags: FlagNoScript | FlagLoading | FlagStale | FlagFast,
+			Group: "connection", Summary: "Handshakes with the Redis server.",
+			Since: "6.0.0", Complexity: "O(1)",
+			handle: (*DefaultCommandHandler).handleHello,
+		},
+		{
+			Name: "client", Arity: -2, Flags: FlagAdmin | FlagNoScript | FlagLoading | FlagStale,
+			Group: "connection", Summary: "A container for client connection commands.",
+			Since: "2.4.0", Complexity: "Depends on subcommand.",
+			handle: (*DefaultCommandHandler).handleClient,
+		},
+		{
+			Name: "config", Arity: -2, Flags: FlagAdmin | FlagNoScript | FlagLoading | FlagStale,
+			Group: "server", Summary: "A container for server configuration commands.",
+			Since: "2.0.0", Complexity: "Depends on subcommand.",
+			handle: (*DefaultCommandHandler).handleConfig,
+		},
+		{
+			Name: "select", Arity: 2, Flags: FlagLoading | FlagStale | FlagFast,
+			Group: "connection", Summary: "Changes the selected database.",
+			Since: "1.0.0", Complexity: "O(1)",
+			handle: (*DefaultCommandHandler).handleSelect,
+		},
+		{
+			Name: "save", Arity: 1, Flags: FlagAdmin | FlagNoScript,
+			Group: "server", Summary: "Synchronously saves the database(s) to disk.",
+			Since: "1.0.0", Complexity: "O(N) where N is the total number of keys in all databases",
+			handle: (*DefaultCommandHandler).handleSave,
+		},
+		{
+			Name: "bgsave", Arity: -1, Flags: FlagAdmin | FlagNoScript,
+			Group: "server", Summary: "Asynchronously saves the database(s) to disk.",
+			Since: "1.0.0", Complexity: "O(N) where N is the total number of keys in all databases",
+			handle: (*DefaultCommandHandler).handleBgsave,
+		},
+		{
+			Name: "bgrewriteaof", Arity: 1, Flags: FlagAdmin | FlagNoScript,
+			Group: "server", Summary: "Asynchronously rewrites the append-only file to disk.",
+			Since: "1.0.0", Complexity: "O(1)",
+			handle: (*DefaultCommandHandler).handleBgrewriteaof,
+		},
+		{
+			Name: "lastsave", Arity: 1, Flags: FlagLoading | FlagStale | FlagFast,
+			Group: "server", Summary: "Returns the Unix timestamp of the last successful save to disk.",
+			Since: "1.0.0", Complexity: "O(1)",
+			handle: (*DefaultCommandHandler).handleLastsave,
+		},
+		{
+			Name: "shutdown", Arity: -1, Flags: FlagAdmin | FlagNoScript | FlagLoading | FlagStale,
+			Group: "server", Summary: "Synchronously saves the database(s) to disk and shuts down the Redis server.",
+			Since: "1.0.0", Complexity: "O(N) when saving, where N is the total number of keys in all databases when saving data, otherwise O(1)",
+			handle: (*DefaultCommandHandler).handleShutdown,
+		},
+		{
+			Name: "command", Arity: -1, Flags: FlagLoading | FlagStale,
+			Group: "server", Summary: "Returns detailed information about all commands.",
+			Since: "2.8.13", Complexity: "O(N) where N is the total number of Redis commands",
+			handle: (*DefaultCommandHandler).handleCommand,
+		},
+	}
+}
+
+// Lookup returns the spec of the command called name, in any case.
+func Lookup(name string) (*Spec, bool) {
+	spec, ok := registry[strings.ToUpper(name)]
+	return spec, ok
+}
+
+// Specs returns the specs of all supported commands sorted by name.
+func Specs() []*Spec {
+	specs := make([]*Spec, 0, len(registry))
+	for _, spec := range registry {
+		specs = append(specs, spec)
+	}
+	sort.Slice(specs, func(i, j int) bool {
+		return specs[i].Name < specs[j].Name
+	})
+	return specs
+}
+
+// IsWrite reports whether command modifies the data set.
+func IsWrite(command protocol.Command) bool {
+	spec, ok := Lookup(command.Name)
+	return ok && spec.Has(FlagWrite)
+}
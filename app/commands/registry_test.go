@@ -0,0 +1,85 @@
+package commands
+
+import (
+	"testing"
+
+	"github.com/jorzel/myredis/app/config"
+	"github.com/jorzel/myredis/app/protocol"
+	"github.com/jorzel/myredis/app/replication"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSpecCheckArity(t *testing.T) {
+	tests := []struct {
+		name     string
+		command  string
+		argc     int
+		expected bool
+	}{
+		{name: "Exact arity", command: "GET", argc: 2, expected: true},
+		{name: "Too many for exact arity", command: "GET", argc: 3, expected: false},
+		{name: "Minimum arity", command: "SET", argc: 3, expected: true},
+		{name: "Above minimum arity", command: "SET", argc: 5, expected: true},
+		{name: "Below minimum arity", command: "DEL", argc: 1, expected: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec, ok := Lookup(tt.command)
+			require.True(t, ok)
+			assert.Equal(t, tt.expected, spec.CheckArity(tt.argc))
+		})
+	}
+}
+
+func TestSpecKeys(t *testing.T) {
+	tests := []struct {
+		name         string
+		command      protocol.Command
+		expectedKeys []string
+	}{
+		{
+			name:         "Single key",
+			command:      protocol.NewCommand("SET", []string{"key", "value", "PX", "100"}),
+			expectedKeys: []string{"key"},
+		},
+		{
+			name:         "Keys up to the last argument",
+			command:      protocol.NewCommand("DEL", []string{"a", "b", "c"}),
+			expectedKeys: []string{"a", "b", "c"},
+		},
+		{
+			name:         "No keys",
+			command:      protocol.NewCommand("PING", nil),
+			expectedKeys: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			spec, ok := Lookup(tt.command.Name)
+			require.True(t, ok)
+			assert.Equal(t, tt.expectedKeys, spec.Keys(tt.command))
+		})
+	}
+}
+
+func TestIsWrite(t *testing.T) {
+	assert.True(t, IsWrite(protocol.NewCommand("set", []string{"a", "b"})))
+	assert.True(t, IsWrite(protocol.NewCommand("DEL", []string{"a"})))
+	assert.False(t, IsWrite(protocol.NewCommand("GET", []string{"a"})))
+	assert.False(t, IsWrite(protocol.NewCommand("UNKNOWN", nil)))
+}
+
+func TestHandleDelPropagatesToReplica(t *testing.T) {
+	master := replication.NewMaster()
+	replicaConn := &MockConn{}
+	_, err := master.AddReplica(replicaConn, 0)
+	require.NoError(t, err)
+	handler := NewCommandHandler(&config.Config{}, WithReplication(master))
+
+	_, err = handle(handler, &MockConn{}, protocol.NewCommand("DEL", []string{"key"}))
+
+	require.NoError(t, err)
+	require.Len(t, replicaConn.writes, 1)
+	assert.Equal(t, "*2\r\n$3\r\nDEL\r\n$3\r\nkey\r\n", string(replicaConn.writes[0]))
+}
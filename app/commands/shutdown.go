@@ -0,0 +1,75 @@
+package commands
+
+import (
+	"context"
+	"strings"
+
+	"github.com/jorzel/myredis/app/client"
+	"github.com/jorzel/myredis/app/protocol"
+)
+
+// ShutdownOptions are the modifiers accepted by SHUTDOWN.
+type ShutdownOptions struct {
+	// Save takes a snapshot even if persistence is off.
+	Save bool
+	// NoSave skips the snapshot even if persistence is on.
+	NoSave bool
+	// Now skips waiting for lagging replicas.
+	Now bool
+	// Force ignores errors that would otherwise prevent the shutdown.
+	Force bool
+}
+
+// Shutdowner stops the server.
+type Shutdowner interface {
+	// Shutdown prepares the server to stop and makes it stop. The server keeps
+	// running if an error is returned.
+	Shutdown(ctx context.Context, opts ShutdownOptions) error
+}
+
+func (h *DefaultCommandHandler) handleShutdown(
+	ctx context.Context, c *client.Client, command protocol.Command,
+) (HandleResult, error) {
+	msg, commandErr := h.executeShutdown(ctx, command)
+	if commandErr == nil {
+		// There is no reply on success, the connection is closed once the
+		// server stops.
+		return HandleResult{}, nil
+	}
+	err := h.sendMsg(ctx, c, msg)
+	return HandleResult{
+		CommandError: commandErr,
+	}, err
+}
+
+func (h *DefaultCommandHandler) executeShutdown(ctx context.Context, command protocol.Command) (protocol.Reply, error) {
+	if h.shutdowner == nil {
+		err := protocol.NewError(command.Name + " is not supported by this server")
+		return err, err
+	}
+
+	var opts ShutdownOptions
+	for _, arg := range command.Args {
+		switch strings.ToUpper(arg) {
+		case "SAVE":
+			opts.Save = true
+		case "NOSAVE":
+			opts.NoSave = true
+		case "NOW":
+			opts.Now = true
+		case "FORCE":
+			opts.Force = true
+		default:
+			return protocol.ErrSyntax, protocol.ErrSyntax
+		}
+	}
+	if opts.Save && opts.NoSave {
+		return protocol.ErrSyntax, protocol.ErrSyntax
+	}
+
+	if err := h.shutdowner.Shutdown(ctx, opts); err != nil {
+		replyErr := protocol.NewError("Errors trying to SHUTDOWN. Check logs.")
+		return replyErr, replyErr
+	}
+	return nil, nil
+}
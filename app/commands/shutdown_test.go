@@ -0,0 +1,78 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/jorzel/myredis/app/config"
+	"github.com/jorzel/myredis/app/protocol"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type mockShutdowner struct {
+	calls []ShutdownOptions
+	err   error
+}
+
+func (m *mockShutdowner) Shutdown(_ context.Context, opts ShutdownOptions) error {
+	m.calls = append(m.calls, opts)
+	return m.err
+}
+
+func TestHandleShutdown(t *testing.T) {
+	tests := []struct {
+		name            string
+		args            []string
+		shutdownErr     error
+		expectedReply   string
+		expectedOptions []ShutdownOptions
+	}{
+		{
+			name:            "Without options",
+			args:            []string{},
+			expectedOptions: []ShutdownOptions{{}},
+		},
+		{
+			name:            "With all options",
+			args:            []string{"nosave", "NOW", "FORCE"},
+			expectedOptions: []ShutdownOptions{{NoSave: true, Now: true, Force: true}},
+		},
+		{
+			name:          "Save and no save",
+			args:          []string{"SAVE", "NOSAVE"},
+			expectedReply: "-ERR syntax error\r\n",
+		},
+		{
+			name:          "Unknown option",
+			args:          []string{"LATER"},
+			expectedReply: "-ERR syntax error\r\n",
+		},
+		{
+			name:            "Failed shutdown",
+			args:            []string{"SAVE"},
+			shutdownErr:     errors.New("disk full"),
+			expectedReply:   "-ERR Errors trying to SHUTDOWN. Check logs.\r\n",
+			expectedOptions: []ShutdownOptions{{Save: true}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			shutdowner := &mockShutdowner{err: tt.shutdownErr}
+			handler := NewCommandHandler(&config.Config{}, WithShutdowner(shutdowner))
+			conn := &MockConn{}
+
+			_, err := handle(handler, conn, protocol.NewCommand("SHUTDOWN", tt.args))
+
+			require.NoError(t, err)
+			if tt.expectedReply == "" {
+				assert.Empty(t, conn.writes, "Expected no reply to a successful shutdown")
+			} else {
+				require.Len(t, conn.writes, 1)
+				assert.Equal(t, tt.expectedReply, string(conn.writes[0]))
+			}
+			assert.Equal(t, tt.expectedOptions, shutdowner.calls)
+		})
+	}
+}
@@ -1,16 +1,150 @@
 package config
 
+import (
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/jorzel/myredis/app/protocol"
+)
+
 const (
 	MasterRole  = "master"
 	ReplicaRole = "replica"
 )
 
+// Policies of fsyncing the append-only file.
+const (
+	AppendFsyncAlways   = "always"
+	AppendFsyncEverySec = "everysec"
+	AppendFsyncNo       = "no"
+)
+
+// RedisVersion is the Redis release the server claims compatibility with.
+const RedisVersion = "7.2.0"
+
 type Node struct {
 	Host string `json:"host"`
 	Port int    `json:"port"`
 }
 
+// Config holds the parameters of the server. They come from the config file
+// and the command line, and some of them can be changed at runtime with
+// CONFIG SET; those are read with the getters below once the server runs.
 type Config struct {
+	// mu guards the parameters that can be changed at runtime.
+	mu sync.RWMutex
+	// File is the config file the parameters were loaded from, if any.
+	File string `json:"file"`
+
 	ReplicaOf  *Node `json:"replica_of"`
 	ServerPort int   `json:"port"`
+	// ReplicaReadOnly makes replicas reject write commands from clients.
+	ReplicaReadOnly bool `json:"replica_read_only"`
+	// ReplTimeout is how many seconds a replica waits for data from its
+	// master before it considers the link lost.
+	ReplTimeout int `json:"repl_timeout"`
+	// ProtoMaxBulkLen limits the length of a single bulk string sent by a client.
+	ProtoMaxBulkLen int `json:"proto_max_bulk_len"`
+	// Dir is the directory persistence files are stored in.
+	Dir string `json:"dir"`
+	// DBFilename is the name of the RDB file. RDB persistence is off if it is empty.
+	DBFilename string `json:"dbfilename"`
+	// SavePoints trigger background saves of the RDB file. Without them,
+	// snapshots are only saved on demand.
+	SavePoints []SavePoint `json:"save"`
+	// AppendOnly turns on logging write commands to the append-only file.
+	AppendOnly bool `json:"appendonly"`
+	// AppendFilename is the name of the append-only file.
+	AppendFilename string `json:"appendfilename"`
+	// AppendFsync is the policy of fsyncing the append-only file, one of AppendFsync*.
+	AppendFsync string `json:"appendfsync"`
+	// AutoAOFRewritePercentage is how much the append-only file has to grow
+	// since the last rewrite to be rewritten automatically, 0 turns it off.
+	AutoAOFRewritePercentage int `json:"auto_aof_rewrite_percentage"`
+	// AutoAOFRewriteMinSize is the size in bytes below which the append-only
+	// file is never rewritten automatically.
+	AutoAOFRewriteMinSize int64 `json:"auto_aof_rewrite_min_size"`
+}
+
+// RDBPath returns the path of the RDB file.
+func (c *Config) RDBPath() string {
+	return filepath.Join(c.Dir, c.DBFilename)
+}
+
+// AOFPath returns the path of the append-only file.
+func (c *Config) AOFPath() string {
+	return filepath.Join(c.Dir, c.AppendFilename)
+}
+
+// Default returns the config the server runs with when nothing is set.
+func Default() *Config {
+	return &Config{
+		ServerPort:               6379,
+		ReplicaReadOnly:          true,
+		ReplTimeout:              60,
+		ProtoMaxBulkLen:          protocol.DefaultMaxBulkLen,
+		Dir:                      ".",
+		DBFilename:               "dump.rdb",
+		SavePoints:               []SavePoint{{Seconds: 3600, Changes: 1}, {Seconds: 300, Changes: 100}, {Seconds: 60, Changes: 10000}},
+		AppendFilename:           "appendonly.aof",
+		AppendFsync:              AppendFsyncEverySec,
+		AutoAOFRewritePercentage: 100,
+		AutoAOFRewriteMinSize:    64 * 1024 * 1024,
+	}
+}
+
+// IsReplicaReadOnly reports whether replicas reject write commands from clients.
+func (c *Config) IsReplicaReadOnly() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.ReplicaReadOnly
+}
+
+// Master returns the master the server replicates, nil on a master.
+func (c *Config) Master() *Node {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.ReplicaOf
+}
+
+// SetMaster records the master the server replicates after REPLICAOF, so
+// CONFIG GET and CONFIG REWRITE reflect the current role.
+func (c *Config) SetMaster(master *Node) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ReplicaOf = master
+}
+
+// ReplicationTimeout returns how long a replica waits for data from its
+// master. Non-positive values mean the default of 60 seconds.
+func (c *Config) ReplicationTimeout() time.Duration {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.ReplTimeout <= 0 {
+		return 60 * time.Second
+	}
+	return time.Duration(c.ReplTimeout) * time.Second
+}
+
+// MaxBulkLen returns the limit of the length of a single bulk string.
+func (c *Config) MaxBulkLen() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.ProtoMaxBulkLen
+}
+
+// SaveParams returns the save points.
+func (c *Config) SaveParams() []SavePoint {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.SavePoints
+}
+
+// AOFParams returns the fsync policy and the automatic rewrite settings of
+// the append-only file.
+func (c *Config) AOFParams() (fsync string, rewritePercentage int, rewriteMinSize int64) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.AppendFsync, c.AutoAOFRewritePercentage, c.AutoAOFRewriteMinSize
 }
@@ -0,0 +1,176 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jorzel/myredis/app/protocol"
+)
+
+// rewriteMarker separates the directives appended by CONFIG REWRITE from
+// the ones written by hand.
+const rewriteMarker = "# Generated by CONFIG REWRITE"
+
+// LoadFile applies the directives of a redis.conf style file: one parameter
+// per line, followed by its arguments, which are quoted like in inline
+// commands. Lines starting with # are comments. Every save directive adds
+// save points to the ones of the previous directives, unless it is empty.
+func (c *Config) LoadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+	var savePoints []string
+	for i, line := range strings.Split(string(data), "\n") {
+		name, value, ok, err := parseDirective(line)
+		if err != nil {
+			return fmt.Errorf("%s:%d: %w", path, i+1, err)
+		}
+		if !ok {
+			continue
+		}
+		if name == "save" {
+			// An empty save directive removes the save points set so far.
+			if value == "" {
+				savePoints = nil
+			}
+			savePoints = append(savePoints, value)
+			value = strings.Join(savePoints, " ")
+		}
+		if err := c.Apply(name, value); err != nil {
+			return fmt.Errorf("%s:%d: %w", path, i+1, err)
+		}
+	}
+	c.File = path
+	return nil
+}
+
+// Rewrite writes the current parameters to the config file. Directives of
+// the file are updated in place, and parameters that differ from their
+// defaults but are not in the file yet are appended to it. Comments stay.
+func (c *Config) Rewrite() error {
+	if c.File == "" {
+		return errors.New("the server is running without a config file")
+	}
+	data, err := os.ReadFile(c.File)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	defaults := Default()
+	written := map[string]bool{}
+	marked := false
+	var lines []string
+	for _, line := range strings.Split(strings.TrimRight(string(data), "\n"), "\n") {
+		name, _, ok, err := parseDirective(line)
+		if err != nil || !ok {
+			marked = marked || strings.TrimSpace(line) == rewriteMarker
+			if len(lines) > 0 || line != "" {
+				lines = append(lines, line)
+			}
+			continue
+		}
+		p, known := lookupParam(name)
+		if !known {
+			lines = append(lines, line)
+			continue
+		}
+		// A parameter given several times is written once, where it first was.
+		if !written[p.name] {
+			lines = append(lines, p.directive(c))
+			written[p.name] = true
+		}
+	}
+
+	var appended []string
+	for i := range params {
+		p := &params[i]
+		if !written[p.name] && p.get(c) != p.get(defaults) {
+			appended = append(appended, p.directive(c))
+		}
+	}
+	if len(appended) > 0 && !marked {
+		lines = append(lines, rewriteMarker)
+	}
+	lines = append(lines, appended...)
+	content := strings.Join(lines, "\n") + "\n"
+
+	tmp, err := os.CreateTemp(filepath.Dir(c.File), "temp-*.conf")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(content); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write temporary file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close temporary file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), c.File); err != nil {
+		return fmt.Errorf("failed to rename temporary file: %w", err)
+	}
+	return nil
+}
+
+// directive spells the current value of the parameter as a config file line.
+func (p *param) directive(c *Config) string {
+	value := p.get(c)
+	if value == "" {
+		return p.name + ` ""`
+	}
+	if p.list || !strings.ContainsAny(value, " \t\r\n\"'\\") {
+		return p.name + " " + value
+	}
+	return p.name + " " + quote(value)
+}
+
+// parseDirective splits a config file line into the name of the parameter
+// and its value, with the arguments joined by spaces. It reports false for
+// blank lines and comments.
+func parseDirective(line string) (name, value string, ok bool, err error) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", "", false, nil
+	}
+	args, err := protocol.SplitArgs(line)
+	if err != nil {
+		return "", "", false, err
+	}
+	if len(args) < 2 {
+		return "", "", false, fmt.Errorf("wrong number of arguments for %s", args[0])
+	}
+	return strings.ToLower(args[0]), strings.Join(args[1:], " "), true, nil
+}
+
+// quote double quotes value, escaping it the way SplitArgs unescapes it.
+func quote(value string) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for i := 0; i < len(value); i++ {
+		switch ch := value[i]; ch {
+		case '"', '\\':
+			sb.WriteByte('\\')
+			sb.WriteByte(ch)
+		case '\n':
+			sb.WriteString(`\n`)
+		case '\r':
+			sb.WriteString(`\r`)
+		case '\t':
+			sb.WriteString(`\t`)
+		default:
+			if ch < ' ' || ch == 0x7f {
+				fmt.Fprintf(&sb, `\x%02x`, ch)
+			} else {
+				sb.WriteByte(ch)
+			}
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
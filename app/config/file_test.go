@@ -0,0 +1,119 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "redis.conf")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	return path
+}
+
+func TestConfigLoadFile(t *testing.T) {
+	path := writeConfigFile(t, `# Comment
+port 7000
+
+replicaof localhost 6380
+dbfilename "my dump.rdb"
+save 900 1
+save 60 100
+appendonly yes
+`)
+	c := Default()
+
+	require.NoError(t, c.LoadFile(path))
+
+	assert.Equal(t, path, c.File)
+	assert.Equal(t, 7000, c.ServerPort)
+	assert.Equal(t, &Node{Host: "localhost", Port: 6380}, c.ReplicaOf)
+	assert.Equal(t, "my dump.rdb", c.DBFilename)
+	assert.Equal(t, []SavePoint{{Seconds: 900, Changes: 1}, {Seconds: 60, Changes: 100}}, c.SavePoints)
+	assert.True(t, c.AppendOnly)
+}
+
+func TestConfigLoadFileEmptySave(t *testing.T) {
+	c := Default()
+
+	require.NoError(t, c.LoadFile(writeConfigFile(t, "save \"\"\n")))
+
+	assert.Empty(t, c.SavePoints)
+}
+
+func TestConfigLoadFileInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{name: "unknown directive", content: "port 7000\nmaxclients 10\n"},
+		{name: "invalid value", content: "port nope\n"},
+		{name: "missing value", content: "port\n"},
+		{name: "unbalanced quotes", content: "dbfilename \"dump.rdb\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Error(t, Default().LoadFile(writeConfigFile(t, tt.content)))
+		})
+	}
+}
+
+func TestConfigRewrite(t *testing.T) {
+	path := writeConfigFile(t, `# Server
+port 7000
+save 900 1
+save 60 100
+`)
+	c := Default()
+	require.NoError(t, c.LoadFile(path))
+	require.NoError(t, c.Set([][2]string{{"save", "300 10"}, {"appendfsync", "always"}}))
+
+	require.NoError(t, c.Rewrite())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, `# Server
+port 7000
+save 300 10
+# Generated by CONFIG REWRITE
+appendfsync always
+`, string(data))
+
+	reloaded := Default()
+	require.NoError(t, reloaded.LoadFile(path))
+	assert.Equal(t, c.Get("*"), reloaded.Get("*"))
+
+	require.NoError(t, c.Set([][2]string{{"appendfsync", "no"}}))
+	require.NoError(t, c.Rewrite())
+	data, err = os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, `# Server
+port 7000
+save 300 10
+# Generated by CONFIG REWRITE
+appendfsync no
+`, string(data), "Expected the rewrite to update its own directives")
+}
+
+func TestConfigRewriteWithoutFile(t *testing.T) {
+	assert.Error(t, Default().Rewrite())
+}
+
+func TestConfigRewriteQuotesValues(t *testing.T) {
+	c := Default()
+	dir := filepath.Join(t.TempDir(), `my "data"`)
+	require.NoError(t, os.Mkdir(dir, 0o755))
+	require.NoError(t, c.Apply("dir", dir))
+	c.File = filepath.Join(t.TempDir(), "redis.conf")
+
+	require.NoError(t, c.Rewrite())
+
+	reloaded := Default()
+	require.NoError(t, reloaded.LoadFile(c.File))
+	assert.Equal(t, dir, reloaded.Dir)
+}
@@ -0,0 +1,35 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var memoryUnits = []struct {
+	suffix     string
+	multiplier int64
+}{
+	// Longer suffixes go first, so "kb" is not taken for "b".
+	{"kb", 1024}, {"mb", 1024 * 1024}, {"gb", 1024 * 1024 * 1024},
+	{"k", 1000}, {"m", 1000 * 1000}, {"g", 1000 * 1000 * 1000},
+	{"b", 1},
+}
+
+// ParseMemory parses a memory size the way redis.conf spells it: a number of
+// bytes, optionally followed by a case-insensitive unit, where k, m and g are
+// powers of 1000 and kb, mb and gb powers of 1024.
+func ParseMemory(value string) (int64, error) {
+	number, multiplier := strings.ToLower(value), int64(1)
+	for _, unit := range memoryUnits {
+		if strings.HasSuffix(number, unit.suffix) {
+			number, multiplier = strings.TrimSuffix(number, unit.suffix), unit.multiplier
+			break
+		}
+	}
+	size, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("invalid memory size %q", value)
+	}
+	return size * multiplier, nil
+}
@@ -0,0 +1,43 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMemory(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    string
+		expected int64
+	}{
+		{name: "bytes", value: "100", expected: 100},
+		{name: "bytes with unit", value: "100b", expected: 100},
+		{name: "kilobytes", value: "1k", expected: 1000},
+		{name: "kibibytes", value: "1kb", expected: 1024},
+		{name: "megabytes", value: "2m", expected: 2000000},
+		{name: "mebibytes", value: "64mb", expected: 64 * 1024 * 1024},
+		{name: "gigabytes", value: "1g", expected: 1000000000},
+		{name: "gibibytes", value: "1GB", expected: 1024 * 1024 * 1024},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			size, err := ParseMemory(tt.value)
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, size)
+		})
+	}
+}
+
+func TestParseMemoryInvalid(t *testing.T) {
+	for _, value := range []string{"", "mb", "1tb", "-1", "1.5mb"} {
+		t.Run(value, func(t *testing.T) {
+			_, err := ParseMemory(value)
+
+			assert.Error(t, err)
+		})
+	}
+}
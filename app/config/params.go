@@ -0,0 +1,322 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrUnknownParam is returned for a parameter the server does not have.
+	ErrUnknownParam = errors.New("unknown parameter")
+	// ErrImmutableParam is returned when CONFIG SET changes a parameter
+	// that is only read at startup.
+	ErrImmutableParam = errors.New("can't set immutable config")
+)
+
+// ParamError reports the parameter a value could not be set for.
+type ParamError struct {
+	Name string
+	Err  error
+}
+
+func (e *ParamError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Name, e.Err)
+}
+
+func (e *ParamError) Unwrap() error {
+	return e.Err
+}
+
+// param describes a parameter of the server, as spelled in the config file
+// and in CONFIG GET and SET.
+type param struct {
+	name string
+	// mutable parameters can be changed at runtime with CONFIG SET.
+	mutable bool
+	// list parameters hold several arguments, which are written unquoted
+	// to the config file.
+	list bool
+	get  func(c *Config) string
+	set  func(c *Config, value string) error
+}
+
+var params = []param{
+	{
+		name: "port",
+		get:  func(c *Config) string { return strconv.Itoa(c.ServerPort) },
+		set: func(c *Config, value string) error {
+			port, err := strconv.Atoi(value)
+			if err != nil || port < 1 || port > 65535 {
+				return fmt.Errorf("port must be between 1 and 65535, got %s", value)
+			}
+			c.ServerPort = port
+			return nil
+		},
+	},
+	{
+		name: "replicaof",
+		list: true,
+		get: func(c *Config) string {
+			if c.ReplicaOf == nil {
+				return ""
+			}
+			return c.ReplicaOf.Host + " " + strconv.Itoa(c.ReplicaOf.Port)
+		},
+		set: func(c *Config, value string) error {
+			node, err := parseNode(value)
+			if err != nil {
+				return err
+			}
+			c.ReplicaOf = node
+			return nil
+		},
+	},
+	{
+		name:    "replica-read-only",
+		mutable: true,
+		get:     func(c *Config) string { return formatBool(c.ReplicaReadOnly) },
+		set: func(c *Config, value string) error {
+			return parseBool(value, &c.ReplicaReadOnly)
+		},
+	},
+	{
+		name:    "repl-timeout",
+		mutable: true,
+		get:     func(c *Config) string { return strconv.Itoa(c.ReplTimeout) },
+		set: func(c *Config, value string) error {
+			timeout, err := strconv.Atoi(value)
+			if err != nil || timeout < 1 {
+				return fmt.Errorf("repl-timeout must be a positive number of seconds, got %s", value)
+			}
+			c.ReplTimeout = timeout
+			return nil
+		},
+	},
+	{
+		name:    "proto-max-bulk-len",
+		mutable: true,
+		get:     func(c *Config) string { return strconv.Itoa(c.ProtoMaxBulkLen) },
+		set: func(c *Config, value string) error {
+			size, err := ParseMemory(value)
+			if err != nil || size < 1 || size > int64(^uint32(0)>>1) {
+				return fmt.Errorf("proto-max-bulk-len must be a positive size, got %s", value)
+			}
+			c.ProtoMaxBulkLen = int(size)
+			return nil
+		},
+	},
+	{
+		name: "dir",
+		get:  func(c *Config) string { return c.Dir },
+		set: func(c *Config, value string) error {
+			if info, err := os.Stat(value); err != nil || !info.IsDir() {
+				return fmt.Errorf("dir must be an existing directory, got %s", value)
+			}
+			c.Dir = value
+			return nil
+		},
+	},
+	{
+		name: "dbfilename",
+		get:  func(c *Config) string { return c.DBFilename },
+		set: func(c *Config, value string) error {
+			if strings.ContainsRune(value, filepath.Separator) {
+				return fmt.Errorf("dbfilename can't be a path, just a filename, got %s", value)
+			}
+			c.DBFilename = value
+			return nil
+		},
+	},
+	{
+		name:    "save",
+		mutable: true,
+		list:    true,
+		get:     func(c *Config) string { return FormatSavePoints(c.SavePoints) },
+		set: func(c *Config, value string) error {
+			points, err := ParseSavePoints(value)
+			if err != nil {
+				return err
+			}
+			c.SavePoints = points
+			return nil
+		},
+	},
+	{
+		name: "appendonly",
+		get:  func(c *Config) string { return formatBool(c.AppendOnly) },
+		set: func(c *Config, value string) error {
+			return parseBool(value, &c.AppendOnly)
+		},
+	},
+	{
+		name: "appendfilename",
+		get:  func(c *Config) string { return c.AppendFilename },
+		set: func(c *Config, value string) error {
+			if value == "" || strings.ContainsRune(value, filepath.Separator) {
+				return fmt.Errorf("appendfilename can't be a path, just a filename, got %s", value)
+			}
+			c.AppendFilename = value
+			return nil
+		},
+	},
+	{
+		name:    "appendfsync",
+		mutable: true,
+		get:     func(c *Config) string { return c.AppendFsync },
+		set: func(c *Config, value string) error {
+			switch value {
+			case AppendFsyncAlways, AppendFsyncEverySec, AppendFsyncNo:
+				c.AppendFsync = value
+				return nil
+			}
+			return fmt.Errorf("appendfsync must be always, everysec or no, got %s", value)
+		},
+	},
+	{
+		name:    "auto-aof-rewrite-percentage",
+		mutable: true,
+		get:     func(c *Config) string { return strconv.Itoa(c.AutoAOFRewritePercentage) },
+		set: func(c *Config, value string) error {
+			percentage, err := strconv.Atoi(value)
+			if err != nil || percentage < 0 {
+				return fmt.Errorf("auto-aof-rewrite-percentage must be a non-negative integer, got %s", value)
+			}
+			c.AutoAOFRewritePercentage = percentage
+			return nil
+		},
+	},
+	{
+		name:    "auto-aof-rewrite-min-size",
+		mutable: true,
+		get:     func(c *Config) string { return strconv.FormatInt(c.AutoAOFRewriteMinSize, 10) },
+		set: func(c *Config, value string) error {
+			size, err := ParseMemory(value)
+			if err != nil {
+				return err
+			}
+			c.AutoAOFRewriteMinSize = size
+			return nil
+		},
+	},
+}
+
+func lookupParam(name string) (*param, bool) {
+	name = strings.ToLower(name)
+	for i := range params {
+		if params[i].name == name {
+			return &params[i], true
+		}
+	}
+	return nil, false
+}
+
+// Apply sets the parameter name, as the config file and the command line
+// do before the server starts, so immutable parameters can be set too.
+func (c *Config) Apply(name, value string) error {
+	p, ok := lookupParam(name)
+	if !ok {
+		return &ParamError{Name: name, Err: ErrUnknownParam}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := p.set(c, value); err != nil {
+		return &ParamError{Name: p.name, Err: err}
+	}
+	return nil
+}
+
+// Set changes parameters at runtime, given as pairs of names and values.
+// Either all of them are changed or, if one fails, none is.
+func (c *Config) Set(pairs [][2]string) error {
+	targets := make([]*param, len(pairs))
+	for i, pair := range pairs {
+		p, ok := lookupParam(pair[0])
+		if !ok {
+			return &ParamError{Name: pair[0], Err: ErrUnknownParam}
+		}
+		if !p.mutable {
+			return &ParamError{Name: p.name, Err: ErrImmutableParam}
+		}
+		for _, other := range targets[:i] {
+			if other == p {
+				return &ParamError{Name: p.name, Err: errors.New("duplicate parameter")}
+			}
+		}
+		targets[i] = p
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	previous := make([]string, len(targets))
+	for i, p := range targets {
+		previous[i] = p.get(c)
+	}
+	for i, p := range targets {
+		if err := p.set(c, pairs[i][1]); err != nil {
+			for j := range i {
+				// Previous values were valid, so they are set back.
+				targets[j].set(c, previous[j])
+			}
+			return &ParamError{Name: p.name, Err: err}
+		}
+	}
+	return nil
+}
+
+// Get returns the names and values of the parameters matching any of the
+// glob-style patterns, in the order they are declared.
+func (c *Config) Get(patterns ...string) [][2]string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	var matched [][2]string
+	for _, p := range params {
+		for _, pattern := range patterns {
+			if ok, _ := path.Match(strings.ToLower(pattern), p.name); ok {
+				matched = append(matched, [2]string{p.name, p.get(c)})
+				break
+			}
+		}
+	}
+	return matched
+}
+
+func parseBool(value string, target *bool) error {
+	switch strings.ToLower(value) {
+	case "yes", "true", "1":
+		*target = true
+	case "no", "false", "0":
+		*target = false
+	default:
+		return fmt.Errorf("argument must be 'yes' or 'no', got %s", value)
+	}
+	return nil
+}
+
+func formatBool(value bool) string {
+	if value {
+		return "yes"
+	}
+	return "no"
+}
+
+// parseNode parses the address of a master as "<host> <port>". An empty
+// value or "no one" means no master.
+func parseNode(value string) (*Node, error) {
+	if value == "" || strings.EqualFold(value, "no one") {
+		return nil, nil
+	}
+	parts := strings.Fields(value)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("replicaof must be in the format <host> <port>, got %s", value)
+	}
+	port, err := strconv.Atoi(parts[1])
+	if err != nil || port < 1 || port > 65535 {
+		return nil, fmt.Errorf("invalid port number in replicaof: %s", parts[1])
+	}
+	return &Node{Host: parts[0], Port: port}, nil
+}
@@ -0,0 +1,114 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigApply(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name     string
+		value    string
+		expected func(c *Config) any
+		want     any
+	}{
+		{name: "port", value: "7000", expected: func(c *Config) any { return c.ServerPort }, want: 7000},
+		{name: "replicaof", value: "localhost 6380", expected: func(c *Config) any { return c.ReplicaOf }, want: &Node{Host: "localhost", Port: 6380}},
+		{name: "replica-read-only", value: "no", expected: func(c *Config) any { return c.ReplicaReadOnly }, want: false},
+		{name: "repl-timeout", value: "5", expected: func(c *Config) any { return c.ReplTimeout }, want: 5},
+		{name: "proto-max-bulk-len", value: "1mb", expected: func(c *Config) any { return c.ProtoMaxBulkLen }, want: 1024 * 1024},
+		{name: "dir", value: dir, expected: func(c *Config) any { return c.Dir }, want: dir},
+		{name: "save", value: "60 10", expected: func(c *Config) any { return c.SavePoints }, want: []SavePoint{{Seconds: 60, Changes: 10}}},
+		{name: "appendonly", value: "yes", expected: func(c *Config) any { return c.AppendOnly }, want: true},
+		{name: "appendfsync", value: "always", expected: func(c *Config) any { return c.AppendFsync }, want: AppendFsyncAlways},
+		{name: "auto-aof-rewrite-min-size", value: "1k", expected: func(c *Config) any { return c.AutoAOFRewriteMinSize }, want: int64(1000)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := Default()
+
+			require.NoError(t, c.Apply(tt.name, tt.value))
+
+			assert.Equal(t, tt.want, tt.expected(c))
+		})
+	}
+}
+
+func TestConfigApplyInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+	}{
+		{name: "port", value: "0"},
+		{name: "replicaof", value: "localhost"},
+		{name: "replica-read-only", value: "maybe"},
+		{name: "repl-timeout", value: "0"},
+		{name: "dir", value: "/does/not/exist"},
+		{name: "dbfilename", value: "dir/dump.rdb"},
+		{name: "appendfsync", value: "sometimes"},
+		{name: "auto-aof-rewrite-percentage", value: "-1"},
+		{name: "unknown", value: "1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Default().Apply(tt.name, tt.value)
+
+			var paramErr *ParamError
+			require.ErrorAs(t, err, &paramErr)
+			assert.Equal(t, tt.name, paramErr.Name)
+		})
+	}
+}
+
+func TestConfigSet(t *testing.T) {
+	c := Default()
+
+	err := c.Set([][2]string{{"APPENDFSYNC", "no"}, {"save", ""}})
+
+	require.NoError(t, err)
+	assert.Equal(t, AppendFsyncNo, c.AppendFsync)
+	assert.Empty(t, c.SaveParams())
+}
+
+func TestConfigSetFails(t *testing.T) {
+	tests := []struct {
+		name     string
+		pairs    [][2]string
+		expected error
+	}{
+		{name: "unknown", pairs: [][2]string{{"nope", "1"}}, expected: ErrUnknownParam},
+		{name: "immutable", pairs: [][2]string{{"port", "7000"}}, expected: ErrImmutableParam},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.ErrorIs(t, Default().Set(tt.pairs), tt.expected)
+		})
+	}
+}
+
+func TestConfigSetIsAtomic(t *testing.T) {
+	c := Default()
+
+	err := c.Set([][2]string{{"appendfsync", "always"}, {"auto-aof-rewrite-percentage", "-1"}})
+
+	assert.Error(t, err)
+	assert.Equal(t, AppendFsyncEverySec, c.AppendFsync, "Expected the first parameter to be set back")
+}
+
+func TestConfigGet(t *testing.T) {
+	c := Default()
+
+	assert.Equal(t, [][2]string{
+		{"appendonly", "no"},
+		{"appendfilename", "appendonly.aof"},
+		{"appendfsync", "everysec"},
+	}, c.Get("append*"))
+	assert.Equal(t, [][2]string{
+		{"port", "6379"},
+		{"save", "3600 1 300 100 60 10000"},
+	}, c.Get("PORT", "save"))
+	assert.Empty(t, c.Get("nope"))
+}
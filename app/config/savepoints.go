@@ -0,0 +1,46 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// SavePoint triggers a background save once at least Changes writes happened
+// and Seconds passed since the last save.
+type SavePoint struct {
+	Seconds int `json:"seconds"`
+	Changes int `json:"changes"`
+}
+
+// ParseSavePoints parses save points the way redis.conf spells them: pairs
+// of seconds and changes separated by spaces, as in "3600 1 300 100". An
+// empty value means no save points.
+func ParseSavePoints(value string) ([]SavePoint, error) {
+	fields := strings.Fields(value)
+	if len(fields)%2 != 0 {
+		return nil, fmt.Errorf("invalid save points %q, expected pairs of seconds and changes", value)
+	}
+	var points []SavePoint
+	for i := 0; i < len(fields); i += 2 {
+		seconds, err := strconv.Atoi(fields[i])
+		if err != nil || seconds < 1 {
+			return nil, fmt.Errorf("invalid save point seconds %q", fields[i])
+		}
+		changes, err := strconv.Atoi(fields[i+1])
+		if err != nil || changes < 0 {
+			return nil, fmt.Errorf("invalid save point changes %q", fields[i+1])
+		}
+		points = append(points, SavePoint{Seconds: seconds, Changes: changes})
+	}
+	return points, nil
+}
+
+// FormatSavePoints spells points the way ParseSavePoints parses them.
+func FormatSavePoints(points []SavePoint) string {
+	fields := make([]string, 0, 2*len(points))
+	for _, point := range points {
+		fields = append(fields, strconv.Itoa(point.Seconds), strconv.Itoa(point.Changes))
+	}
+	return strings.Join(fields, " ")
+}
@@ -0,0 +1,53 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSavePoints(t *testing.T) {
+	tests := []struct {
+		name     string
+		value    string
+		expected []SavePoint
+	}{
+		{name: "none", value: "", expected: nil},
+		{name: "single", value: "60 10", expected: []SavePoint{{Seconds: 60, Changes: 10}}},
+		{
+			name:  "several",
+			value: "3600 1  300 100",
+			expected: []SavePoint{
+				{Seconds: 3600, Changes: 1},
+				{Seconds: 300, Changes: 100},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			points, err := ParseSavePoints(tt.value)
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, points)
+		})
+	}
+}
+
+func TestParseSavePointsInvalid(t *testing.T) {
+	for _, value := range []string{"60", "60 10 300", "0 1", "60 -1", "sixty 1"} {
+		t.Run(value, func(t *testing.T) {
+			_, err := ParseSavePoints(value)
+
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestFormatSavePoints(t *testing.T) {
+	assert.Equal(t, "3600 1 300 100", FormatSavePoints([]SavePoint{
+		{Seconds: 3600, Changes: 1},
+		{Seconds: 300, Changes: 100},
+	}))
+	assert.Equal(t, "", FormatSavePoints(nil))
+}
@@ -5,8 +5,10 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
 	"strconv"
 	"strings"
+	"syscall"
 
 	"github.com/jorzel/myredis/app/config"
 	"github.com/jorzel/myredis/app/server"
@@ -17,9 +19,11 @@ func main() {
 	logger := zerolog.New(os.Stderr).With().Timestamp().Logger()
 	zerolog.TimeFieldFormat = zerolog.TimeFormatUnixMs
 	zerolog.SetGlobalLevel(zerolog.DebugLevel)
-	ctx := logger.WithContext(context.Background())
+	// SIGINT and SIGTERM stop the server gracefully, a second one kills it.
+	ctx, stop := signal.NotifyContext(logger.WithContext(context.Background()), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	cfg, err := getInitSpecsFromArgs()
+	cfg, err := getInitSpecsFromArgs(os.Args[1:])
 	if err != nil {
 		logger.Err(err).Msg("Failed to parse initialization specifications from arguments")
 		os.Exit(1)
@@ -27,70 +31,66 @@ func main() {
 
 	logger.Info().Interface("config", cfg).Msg("Starting configuration")
 
-	var srv server.Server
-	if cfg.ReplicaOf == nil {
-		srv, err = server.NewMasterServer(cfg)
-	} else {
-		srv, err = server.NewReplicaServer(cfg)
-	}
-
+	srv, err := server.NewServer(cfg)
 	if err != nil {
 		logger.Err(err).Msg("Failed to initialize server")
 		os.Exit(1)
 	}
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
 	err = srv.Start(ctx)
 	if err != nil {
 		logger.Err(err).Msg("Failed to start server")
 		os.Exit(1)
 	}
+	logger.Info().Msg("Server exited")
 }
 
-func getInitSpecsFromArgs() (*config.Config, error) {
-	port := flag.Int("port", 6379, "Port to listen on")
-	replicaOf := flag.String("replicaof", "", "Address of the master server")
-	flag.Parse()
-
-	if port == nil {
-		return nil, fmt.Errorf("port flag is required")
-	}
-	if *port < 1 || *port > 65535 {
-		return nil, fmt.Errorf("port must be between 1 and 65535, got %d", *port)
-	}
-
-	var err error
-	var deserializedReplicaOf *config.Node
-	if replicaOf == nil || *replicaOf == "" {
-		deserializedReplicaOf = nil
-	} else {
-		deserializedReplicaOf, err = validateReplicaOf(*replicaOf)
-		if err != nil {
-			return nil, fmt.Errorf("invalid replicaof address: %w", err)
+// getInitSpecsFromArgs builds the config from a redis.conf style file, given
+// as the first argument, and the flags following it, which take precedence
+// over the file.
+func getInitSpecsFromArgs(args []string) (*config.Config, error) {
+	cfg := config.Default()
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		if err := cfg.LoadFile(args[0]); err != nil {
+			return nil, err
 		}
+		args = args[1:]
 	}
 
-	return &config.Config{
-		ReplicaOf:  deserializedReplicaOf,
-		ServerPort: *port,
-	}, nil
-}
-
-func validateReplicaOf(replicaOf string) (*config.Node, error) {
-	if replicaOf == "" {
-		return nil, nil
+	defaults := config.Default()
+	flags := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	flags.Int("port", defaults.ServerPort, "Port to listen on")
+	flags.String("replicaof", "", "Address of the master server as \"<host> <port>\"")
+	flags.String("replica-read-only", defaults.Get("replica-read-only")[0][1], "Reject write commands from clients on replicas: yes or no")
+	flags.Int("repl-timeout", defaults.ReplTimeout, "Seconds a replica waits for data from its master before reconnecting")
+	flags.String("proto-max-bulk-len", strconv.Itoa(defaults.ProtoMaxBulkLen), "Maximum length of a single bulk string")
+	flags.String("dir", defaults.Dir, "Directory the persistence files are stored in")
+	flags.String("dbfilename", defaults.DBFilename, "Name of the RDB file, empty to turn RDB persistence off")
+	flags.String("save", config.FormatSavePoints(defaults.SavePoints), "Save points as pairs of seconds and changes, empty to save only on demand")
+	flags.String("appendonly", defaults.Get("appendonly")[0][1], "Log write commands to the append-only file: yes or no")
+	flags.String("appendfilename", defaults.AppendFilename, "Name of the append-only file")
+	flags.String("appendfsync", defaults.AppendFsync, "Policy of fsyncing the append-only file: always, everysec or no")
+	flags.Int("auto-aof-rewrite-percentage", defaults.AutoAOFRewritePercentage, "Growth of the append-only file since the last rewrite, in percent, that triggers a rewrite, 0 to turn it off")
+	flags.String("auto-aof-rewrite-min-size", "64mb", "Minimum size of the append-only file to be rewritten automatically")
+	if err := flags.Parse(args); err != nil {
+		return nil, err
 	}
-
-	parts := strings.Split(replicaOf, " ")
-	if len(parts) != 2 {
-		return nil, fmt.Errorf("replicaof must be in the format <host>:<port>, got %s", replicaOf)
+	if flags.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected argument %s, the config file has to come first", flags.Arg(0))
 	}
 
-	port, err := strconv.Atoi(parts[1])
-	if err != nil || port < 1 || port > 65535 {
-		return nil, fmt.Errorf("invalid port number in replicaof: %s", parts[1])
+	// Only flags given on the command line override the config file.
+	var err error
+	flags.Visit(func(f *flag.Flag) {
+		if err == nil {
+			err = cfg.Apply(f.Name, f.Value.String())
+		}
+	})
+	if err != nil {
+		return nil, err
 	}
-
-	return &config.Node{
-		Host: parts[0],
-		Port: port,
-	}, nil
+	return cfg, nil
 }
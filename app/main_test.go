@@ -0,0 +1,84 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jorzel/myredis/app/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetInitSpecsFromArgs(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "redis.conf")
+	require.NoError(t, os.WriteFile(file, []byte("port 7000\nappendonly yes\n"), 0o644))
+
+	tests := []struct {
+		name   string
+		args   []string
+		assert func(t *testing.T, cfg *config.Config)
+	}{
+		{
+			name: "defaults",
+			args: nil,
+			assert: func(t *testing.T, cfg *config.Config) {
+				assert.Equal(t, config.Default().ServerPort, cfg.ServerPort)
+				assert.False(t, cfg.AppendOnly)
+				assert.True(t, cfg.ReplicaReadOnly)
+			},
+		},
+		{
+			name: "appendonly yes",
+			args: []string{"--appendonly", "yes", "--port", "7001"},
+			assert: func(t *testing.T, cfg *config.Config) {
+				assert.Equal(t, 7001, cfg.ServerPort)
+				assert.True(t, cfg.AppendOnly)
+			},
+		},
+		{
+			name: "replica-read-only no",
+			args: []string{"--replicaof", "localhost 6379", "--replica-read-only", "no"},
+			assert: func(t *testing.T, cfg *config.Config) {
+				assert.Equal(t, &config.Node{Host: "localhost", Port: 6379}, cfg.ReplicaOf)
+				assert.False(t, cfg.ReplicaReadOnly)
+			},
+		},
+		{
+			name: "flags override config file",
+			args: []string{file, "--appendonly", "no"},
+			assert: func(t *testing.T, cfg *config.Config) {
+				assert.Equal(t, 7000, cfg.ServerPort)
+				assert.False(t, cfg.AppendOnly)
+				assert.Equal(t, file, cfg.File)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := getInitSpecsFromArgs(tt.args)
+			require.NoError(t, err)
+			tt.assert(t, cfg)
+		})
+	}
+}
+
+func TestGetInitSpecsFromArgsErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{name: "invalid boolean", args: []string{"--appendonly", "maybe"}},
+		{name: "invalid port", args: []string{"--port", "0"}},
+		{name: "unknown flag", args: []string{"--unknown", "1"}},
+		{name: "config file after flags", args: []string{"--port", "7000", "redis.conf"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := getInitSpecsFromArgs(tt.args)
+			assert.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,428 @@
+package persistence
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jorzel/myredis/app/config"
+	"github.com/jorzel/myredis/app/protocol"
+	"github.com/jorzel/myredis/app/rdb"
+	"github.com/rs/zerolog"
+)
+
+// ErrRewriteInProgress is returned when a rewrite of the append-only file is
+// already running.
+var ErrRewriteInProgress = errors.New("append only file rewriting already in progress")
+
+// AOF logs write commands to an append-only file, which is replayed to
+// restore the data set. How often the file is fsynced depends on the policy:
+// after every command, once per second or whenever the OS decides to.
+//
+// The file is compacted by a rewrite, which replaces it with the commands
+// setting the current keys. Commands appended while the rewrite runs go both
+// to the old file and to a rewrite buffer, which is added to the new file
+// before it takes over.
+type AOF struct {
+	path  string
+	fsync string
+
+	mu   sync.Mutex
+	file *os.File
+	// dirty is set when commands were written since the last fsync.
+	dirty bool
+	stop  chan struct{}
+	done  chan struct{}
+
+	// size is the size of the file, baseSize its size after the last rewrite.
+	size     int64
+	baseSize int64
+	// autoRewritePercentage and autoRewriteMinSize trigger a rewrite once the
+	// file grew by the percentage since the last rewrite and is big enough.
+	autoRewritePercentage int
+	autoRewriteMinSize    int64
+
+	// writeErr and fsyncErr are the errors of the last write and of the
+	// last fsync, nil once one succeeds again.
+	writeErr error
+	fsyncErr error
+
+	rewriting  bool
+	rewriteBuf []byte
+	// rewriteErr is the error of the last rewrite.
+	rewriteErr error
+	// generation changes whenever the file is replaced, so a rewrite started
+	// before that is thrown away.
+	generation int
+}
+
+// NewAOF creates an append-only file at path, fsynced according to the
+// config.AppendFsync* policy fsync. It is not written to until it is opened.
+func NewAOF(path string, fsync string) *AOF {
+	return &AOF{
+		path:  path,
+		fsync: fsync,
+	}
+}
+
+// Path returns the path of the append-only file.
+func (a *AOF) Path() string {
+	return a.path
+}
+
+// Load replays the append-only file, calling apply for every logged command.
+// It reports false if there is no file yet. A file truncated in the middle of
+// a command, as left by a crash, is cut back to the last complete command.
+func (a *AOF) Load(ctx context.Context, apply func(protocol.Command) error) (bool, error) {
+	logger := zerolog.Ctx(ctx)
+	f, err := os.Open(a.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to open aof file: %w", err)
+	}
+	defer f.Close()
+
+	parser := protocol.NewStreamParser(bufio.NewReader(f), protocol.DefaultMaxBulkLen)
+	offset := 0
+	for {
+		command, size, err := parser.ReadCommand()
+		if err == io.EOF {
+			return true, nil
+		}
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			logger.Warn().
+				Str("path", a.path).
+				Int("offset", offset).
+				Msg("AOF file is truncated, dropping the incomplete command at its end")
+			if err := os.Truncate(a.path, int64(offset)); err != nil {
+				return true, fmt.Errorf("failed to truncate aof file: %w", err)
+			}
+			return true, nil
+		}
+		if err != nil {
+			return true, fmt.Errorf("failed to read aof file at offset %d: %w", offset, err)
+		}
+		if err := apply(command); err != nil {
+			return true, fmt.Errorf("failed to replay %s at offset %d of aof file: %w", command.Name, offset, err)
+		}
+		offset += size
+	}
+}
+
+// Create replaces the append-only file with one setting the keys of entries.
+// If the file is open, commands are appended to the new one from then on.
+func (a *AOF) Create(entries []rdb.Entry) error {
+	buf := encodeEntries(entries)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := writeFile(a.path, buf); err != nil {
+		return err
+	}
+	a.generation++
+	a.baseSize = int64(len(buf))
+	a.size = a.baseSize
+	if a.file == nil {
+		return nil
+	}
+	return a.reopenLocked()
+}
+
+// Open opens the file for appending. Failures to fsync it in the background
+// are logged to the logger of ctx.
+func (a *AOF) Open(ctx context.Context) error {
+	a.mu.Lock()
+	err := a.reopenLocked()
+	if err == nil {
+		a.baseSize = a.size
+	}
+	a.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	// The loop runs with any policy, as the policy can change while the
+	// file is open.
+	a.stop = make(chan struct{})
+	a.done = make(chan struct{})
+	go a.syncEverySecond(ctx)
+	return nil
+}
+
+// Append logs command at the end of the file.
+func (a *AOF) Append(command protocol.Command) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.file == nil {
+		return errors.New("aof file is not open")
+	}
+	payload := command.Serialize()
+	n, err := a.file.Write(payload)
+	a.size += int64(n)
+	a.writeErr = err
+	if err != nil {
+		return fmt.Errorf("failed to write aof file: %w", err)
+	}
+	if a.rewriting {
+		a.rewriteBuf = append(a.rewriteBuf, payload...)
+	}
+	if a.fsync == config.AppendFsyncAlways {
+		a.fsyncErr = a.file.Sync()
+		if a.fsyncErr != nil {
+			return fmt.Errorf("failed to fsync aof file: %w", a.fsyncErr)
+		}
+		return nil
+	}
+	a.dirty = true
+	return nil
+}
+
+// SetFsync changes the fsync policy to one of config.AppendFsync*. Commands
+// not fsynced yet are fsynced right away when switching to always.
+func (a *AOF) SetFsync(fsync string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.fsync = fsync
+	if fsync != config.AppendFsyncAlways || a.file == nil || !a.dirty {
+		return nil
+	}
+	a.fsyncErr = a.file.Sync()
+	if a.fsyncErr != nil {
+		return fmt.Errorf("failed to fsync aof file: %w", a.fsyncErr)
+	}
+	a.dirty = false
+	return nil
+}
+
+// Close fsyncs and closes the file.
+func (a *AOF) Close() error {
+	if a.stop != nil {
+		close(a.stop)
+		<-a.done
+		a.stop = nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.file == nil {
+		return nil
+	}
+	err := a.file.Sync()
+	if closeErr := a.file.Close(); err == nil {
+		err = closeErr
+	}
+	a.file = nil
+	return err
+}
+
+// reopenLocked opens the file at the path for appending, in place of the
+// open one, if any.
+func (a *AOF) reopenLocked() error {
+	f, err := os.OpenFile(a.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open aof file: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("failed to stat aof file: %w", err)
+	}
+	if a.file != nil {
+		a.file.Close()
+	}
+	a.file = f
+	a.size = info.Size()
+	a.dirty = false
+	return nil
+}
+
+// SetAutoRewrite sets when the file is rewritten automatically: once it grew
+// by percentage since the last rewrite and is at least minSize bytes. A zero
+// percentage turns automatic rewrites off.
+func (a *AOF) SetAutoRewrite(percentage int, minSize int64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.autoRewritePercentage = percentage
+	a.autoRewriteMinSize = minSize
+}
+
+// NeedsRewrite reports whether the file grew enough to be rewritten
+// automatically, and no rewrite is running.
+func (a *AOF) NeedsRewrite() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.rewriting || a.autoRewritePercentage <= 0 || a.size < a.autoRewriteMinSize {
+		return false
+	}
+	base := max(a.baseSize, 1)
+	return (a.size-base)*100/base >= int64(a.autoRewritePercentage)
+}
+
+// Size returns the size of the file and its size after the last rewrite.
+func (a *AOF) Size() (current, base int64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.size, a.baseSize
+}
+
+// LastWriteErr returns the error of the last write or fsync of the file, nil
+// if both succeeded.
+func (a *AOF) LastWriteErr() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.writeErr != nil {
+		return a.writeErr
+	}
+	return a.fsyncErr
+}
+
+// LastRewriteErr returns the error of the last rewrite, nil if it succeeded.
+func (a *AOF) LastRewriteErr() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.rewriteErr
+}
+
+// RewriteInProgress reports whether a rewrite is running.
+func (a *AOF) RewriteInProgress() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.rewriting
+}
+
+// BackgroundRewrite replaces the file with one setting the keys of entries,
+// without waiting for it. Entries have to be collected with no write running
+// in between, as commands appended from then on are kept in the rewrite
+// buffer. It fails with ErrRewriteInProgress if another rewrite is running.
+func (a *AOF) BackgroundRewrite(ctx context.Context, entries []rdb.Entry) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.rewriting {
+		return ErrRewriteInProgress
+	}
+	a.rewriting = true
+	a.rewriteBuf = nil
+	generation := a.generation
+
+	go func() {
+		logger := zerolog.Ctx(ctx)
+		started := time.Now()
+		size, err := a.rewrite(encodeEntries(entries), generation)
+		if err != nil {
+			logger.Err(err).Msg("Background append only file rewriting failed")
+			return
+		}
+		logger.Info().
+			Int64("size", size).
+			Dur("duration", time.Since(started)).
+			Msg("Background append only file rewriting done")
+	}()
+	return nil
+}
+
+// rewrite writes base to a temporary file, followed by the rewrite buffer,
+// and renames it over the file. The buffer is only added with appends held
+// back, so no command is missing from the new file.
+func (a *AOF) rewrite(base []byte, generation int) (size int64, err error) {
+	tmp, err := os.CreateTemp(filepath.Dir(a.path), "temp-rewrite-*"+filepath.Ext(a.path))
+	if err == nil {
+		defer os.Remove(tmp.Name())
+		_, err = tmp.Write(base)
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	defer func() {
+		a.rewriting = false
+		a.rewriteBuf = nil
+		a.rewriteErr = err
+	}()
+	if err != nil {
+		if tmp != nil {
+			tmp.Close()
+		}
+		return 0, fmt.Errorf("failed to write temporary file: %w", err)
+	}
+	if _, err := tmp.Write(a.rewriteBuf); err != nil {
+		tmp.Close()
+		return 0, fmt.Errorf("failed to write temporary file: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return 0, fmt.Errorf("failed to sync temporary file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, fmt.Errorf("failed to close temporary file: %w", err)
+	}
+	if generation != a.generation {
+		return 0, errors.New("append only file was replaced while rewriting")
+	}
+	if err := os.Rename(tmp.Name(), a.path); err != nil {
+		return 0, fmt.Errorf("failed to rename temporary file: %w", err)
+	}
+	a.generation++
+	if a.file != nil {
+		if err := a.reopenLocked(); err != nil {
+			return 0, err
+		}
+	}
+	a.baseSize = int64(len(base) + len(a.rewriteBuf))
+	a.size = a.baseSize
+	return a.size, nil
+}
+
+func (a *AOF) syncEverySecond(ctx context.Context) {
+	logger := zerolog.Ctx(ctx)
+	defer close(a.done)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-a.stop:
+			return
+		case <-ticker.C:
+			a.mu.Lock()
+			f, dirty := a.file, a.dirty && a.fsync == config.AppendFsyncEverySec
+			if dirty {
+				a.dirty = false
+			}
+			a.mu.Unlock()
+			if !dirty || f == nil {
+				continue
+			}
+			// Appends go on while the file is synced.
+			err := f.Sync()
+			a.mu.Lock()
+			if f == a.file {
+				a.fsyncErr = err
+				// The commands are fsynced again on the next tick.
+				a.dirty = a.dirty || err != nil
+			}
+			a.mu.Unlock()
+			if err != nil {
+				logger.Err(err).Str("path", a.path).Msg("Failed to fsync the AOF file")
+			}
+		}
+	}
+}
+
+// encodeEntries returns the commands setting the keys of entries. Expiry times
+// are logged as absolute ones.
+func encodeEntries(entries []rdb.Entry) []byte {
+	var buf []byte
+	for _, entry := range entries {
+		args := []string{protocol.SET, entry.Key, entry.Record.Value}
+		if entry.Record.ExpireAt != nil {
+			args = append(args, "PXAT", strconv.FormatInt(entry.Record.ExpireAt.UnixMilli(), 10))
+		}
+		buf = append(buf, protocol.BulkArray(args)...)
+	}
+	return buf
+}
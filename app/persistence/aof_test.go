@@ -0,0 +1,200 @@
+package persistence
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jorzel/myredis/app/config"
+	"github.com/jorzel/myredis/app/protocol"
+	"github.com/jorzel/myredis/app/rdb"
+	"github.com/jorzel/myredis/app/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func replayAll(t *testing.T, a *AOF) []protocol.Command {
+	t.Helper()
+	var replayed []protocol.Command
+	found, err := a.Load(context.Background(), func(command protocol.Command) error {
+		replayed = append(replayed, command)
+		return nil
+	})
+	require.NoError(t, err)
+	require.True(t, found)
+	return replayed
+}
+
+func TestAOFAppendAndLoad(t *testing.T) {
+	for _, fsync := range []string{config.AppendFsyncAlways, config.AppendFsyncEverySec, config.AppendFsyncNo} {
+		t.Run(fsync, func(t *testing.T) {
+			a := NewAOF(filepath.Join(t.TempDir(), "appendonly.aof"), fsync)
+			require.NoError(t, a.Open(context.Background()))
+			require.NoError(t, a.Append(protocol.NewCommand("SET", []string{"key", "value"})))
+			require.NoError(t, a.Append(protocol.NewCommand("DEL", []string{"key"})))
+			require.NoError(t, a.Close())
+
+			assert.Equal(t, []protocol.Command{
+				protocol.NewCommand("SET", []string{"key", "value"}),
+				protocol.NewCommand("DEL", []string{"key"}),
+			}, replayAll(t, NewAOF(a.Path(), fsync)))
+		})
+	}
+}
+
+func TestAOFLoadMissingFile(t *testing.T) {
+	a := NewAOF(filepath.Join(t.TempDir(), "appendonly.aof"), config.AppendFsyncNo)
+
+	found, err := a.Load(context.Background(), func(protocol.Command) error { return nil })
+
+	require.NoError(t, err)
+	assert.False(t, found)
+}
+
+func TestAOFLoadTruncatedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "appendonly.aof")
+	complete := protocol.BulkArray([]string{"SET", "key", "value"})
+	truncated := protocol.BulkArray([]string{"SET", "other", "value"})[:10]
+	require.NoError(t, os.WriteFile(path, append(complete, truncated...), 0o644))
+
+	replayed := replayAll(t, NewAOF(path, config.AppendFsyncNo))
+
+	assert.Equal(t, []protocol.Command{protocol.NewCommand("SET", []string{"key", "value"})}, replayed)
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, complete, data, "Expected the incomplete command to be cut off")
+}
+
+func TestAOFLoadCorruptedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "appendonly.aof")
+	require.NoError(t, os.WriteFile(path, []byte("*1\r\n+SET\r\n*1\r\n$4\r\nPING\r\n"), 0o644))
+
+	_, err := NewAOF(path, config.AppendFsyncNo).Load(context.Background(), func(protocol.Command) error { return nil })
+
+	assert.Error(t, err)
+}
+
+func TestAOFCreate(t *testing.T) {
+	a := NewAOF(filepath.Join(t.TempDir(), "appendonly.aof"), config.AppendFsyncNo)
+	require.NoError(t, a.Open(context.Background()))
+	require.NoError(t, a.Append(protocol.NewCommand("SET", []string{"old", "value"})))
+	expireAt := time.UnixMilli(1893456000000)
+
+	require.NoError(t, a.Create([]rdb.Entry{
+		{Key: "key", Record: &storage.KVRecord{Value: "value", ExpireAt: &expireAt}},
+	}))
+	require.NoError(t, a.Append(protocol.NewCommand("DEL", []string{"key"})))
+	require.NoError(t, a.Close())
+
+	assert.Equal(t, []protocol.Command{
+		protocol.NewCommand("SET", []string{"key", "value", "PXAT", "1893456000000"}),
+		protocol.NewCommand("DEL", []string{"key"}),
+	}, replayAll(t, a))
+}
+
+func TestAOFEverySecFsyncFailure(t *testing.T) {
+	a := NewAOF(filepath.Join(t.TempDir(), "appendonly.aof"), config.AppendFsyncEverySec)
+	require.NoError(t, a.Open(context.Background()))
+	defer a.Close()
+	require.NoError(t, a.Append(protocol.NewCommand("SET", []string{"key", "1"})))
+	// Closing the file under the AOF makes the background fsync fail.
+	a.mu.Lock()
+	a.file.Close()
+	a.mu.Unlock()
+
+	require.Eventually(t, func() bool { return a.LastWriteErr() != nil }, 3*time.Second, 10*time.Millisecond)
+	a.mu.Lock()
+	dirty := a.dirty
+	require.NoError(t, a.reopenLocked())
+	a.mu.Unlock()
+	assert.True(t, dirty, "Expected the failed fsync to be retried")
+
+	require.NoError(t, a.Append(protocol.NewCommand("SET", []string{"key", "2"})))
+	require.Eventually(t, func() bool { return a.LastWriteErr() == nil }, 3*time.Second, 10*time.Millisecond,
+		"Expected the status to recover once an fsync succeeds")
+}
+
+func TestAOFBackgroundRewrite(t *testing.T) {
+	a := NewAOF(filepath.Join(t.TempDir(), "appendonly.aof"), config.AppendFsyncNo)
+	require.NoError(t, a.Open(context.Background()))
+	for _, value := range []string{"1", "2", "3"} {
+		require.NoError(t, a.Append(protocol.NewCommand("SET", []string{"key", value})))
+	}
+
+	require.NoError(t, a.BackgroundRewrite(context.Background(), []rdb.Entry{
+		{Key: "key", Record: &storage.KVRecord{Value: "3"}},
+	}))
+	// Written either to the rewrite buffer or, once the rewrite is done, to
+	// the new file; it must not get lost on the switch-over.
+	require.NoError(t, a.Append(protocol.NewCommand("DEL", []string{"key"})))
+	require.Eventually(t, func() bool { return !a.RewriteInProgress() }, time.Second, time.Millisecond)
+	require.NoError(t, a.Append(protocol.NewCommand("SET", []string{"other", "value"})))
+	require.NoError(t, a.Close())
+
+	assert.Equal(t, []protocol.Command{
+		protocol.NewCommand("SET", []string{"key", "3"}),
+		protocol.NewCommand("DEL", []string{"key"}),
+		protocol.NewCommand("SET", []string{"other", "value"}),
+	}, replayAll(t, a))
+}
+
+func TestAOFBackgroundRewriteInProgress(t *testing.T) {
+	a := NewAOF(filepath.Join(t.TempDir(), "appendonly.aof"), config.AppendFsyncNo)
+	require.NoError(t, a.Open(context.Background()))
+	defer a.Close()
+	a.mu.Lock()
+	a.rewriting = true
+	a.mu.Unlock()
+
+	err := a.BackgroundRewrite(context.Background(), nil)
+
+	assert.ErrorIs(t, err, ErrRewriteInProgress)
+}
+
+func TestAOFNeedsRewrite(t *testing.T) {
+	command := protocol.NewCommand("SET", []string{"key", "value"})
+	commandSize := int64(len(command.Serialize()))
+	tests := []struct {
+		name       string
+		percentage int
+		minSize    int64
+		appends    int
+		expected   bool
+	}{
+		{name: "turned off", percentage: 0, minSize: 0, appends: 10, expected: false},
+		{name: "below min size", percentage: 100, minSize: 100 * commandSize, appends: 10, expected: false},
+		{name: "grown enough", percentage: 100, minSize: 0, appends: 2, expected: true},
+		{name: "not grown enough", percentage: 200, minSize: 0, appends: 1, expected: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := NewAOF(filepath.Join(t.TempDir(), "appendonly.aof"), config.AppendFsyncNo)
+			require.NoError(t, a.Create([]rdb.Entry{{Key: "key", Record: &storage.KVRecord{Value: "value"}}}))
+			require.NoError(t, a.Open(context.Background()))
+			defer a.Close()
+			a.SetAutoRewrite(tt.percentage, tt.minSize)
+
+			for range tt.appends {
+				require.NoError(t, a.Append(command))
+			}
+
+			assert.Equal(t, tt.expected, a.NeedsRewrite())
+		})
+	}
+}
+
+func TestAOFSetFsync(t *testing.T) {
+	a := NewAOF(filepath.Join(t.TempDir(), "appendonly.aof"), config.AppendFsyncNo)
+	require.NoError(t, a.Open(context.Background()))
+	defer a.Close()
+	require.NoError(t, a.Append(protocol.NewCommand("SET", []string{"key", "value"})))
+
+	require.NoError(t, a.SetFsync(config.AppendFsyncAlways))
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	assert.False(t, a.dirty, "Expected pending commands to be fsynced")
+	assert.Equal(t, config.AppendFsyncAlways, a.fsync)
+}
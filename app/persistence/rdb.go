@@ -0,0 +1,225 @@
+package persistence
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/jorzel/myredis/app/config"
+	"github.com/jorzel/myredis/app/rdb"
+	"github.com/jorzel/myredis/app/storage"
+	"github.com/rs/zerolog"
+)
+
+// ErrSaveInProgress is returned when a background save is already running.
+var ErrSaveInProgress = errors.New("background save already in progress")
+
+// bgsaveRetryDelay is how long save points wait after a failed background
+// save before trying again.
+const bgsaveRetryDelay = 5 * time.Second
+
+// RDB saves snapshots of the data set to an RDB file and loads them back.
+// Snapshots are written to a temporary file first and renamed into place,
+// so the file is never left half written.
+//
+// It also counts the changes of the data set since the last save, which
+// decide together with the save points when a snapshot is due.
+type RDB struct {
+	path string
+	// fileMu serializes writes of the file.
+	fileMu sync.Mutex
+
+	mu         sync.Mutex
+	inProgress bool
+	lastSave   time.Time
+	changes    int64
+	// bgsaveStarted is when the running or the last background save started.
+	bgsaveStarted time.Time
+	// bgsaveDuration is how long the last background save took, -1 if none ran.
+	bgsaveDuration time.Duration
+	bgsaveErr      error
+}
+
+// NewRDB creates an RDB file at path. Until a save succeeds, the last save
+// time is the time of the creation.
+func NewRDB(path string) *RDB {
+	return &RDB{
+		path:           path,
+		lastSave:       time.Now(),
+		bgsaveDuration: -1,
+	}
+}
+
+// Path returns the path of the RDB file.
+func (r *RDB) Path() string {
+	return r.path
+}
+
+// Load replaces the content of s with the RDB file. It reports false if
+// there is no file yet.
+func (r *RDB) Load(s storage.Storage) (bool, error) {
+	data, err := os.ReadFile(r.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to read rdb file: %w", err)
+	}
+	if err := rdb.Load(data, s); err != nil {
+		return false, fmt.Errorf("failed to load rdb file %s: %w", r.path, err)
+	}
+	return true, nil
+}
+
+// Save writes entries to the RDB file, after a running background save is
+// done. Entries have to be collected with no change counted in between.
+func (r *RDB) Save(entries []rdb.Entry) error {
+	r.mu.Lock()
+	changes := r.changes
+	r.mu.Unlock()
+	if err := r.write(entries); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.saved(changes)
+	return nil
+}
+
+// BackgroundSave writes entries to the RDB file without waiting for it. It
+// fails with ErrSaveInProgress if another background save is running.
+// Entries have to be collected with no change counted in between.
+func (r *RDB) BackgroundSave(ctx context.Context, entries []rdb.Entry) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.inProgress {
+		return ErrSaveInProgress
+	}
+	r.inProgress = true
+	r.bgsaveStarted = time.Now()
+	changes := r.changes
+
+	go func() {
+		logger := zerolog.Ctx(ctx)
+		err := r.write(entries)
+
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.inProgress = false
+		r.bgsaveDuration = time.Since(r.bgsaveStarted)
+		r.bgsaveErr = err
+		if err != nil {
+			logger.Err(err).Msg("Background save failed")
+			return
+		}
+		r.saved(changes)
+		logger.Info().
+			Int("keys", len(entries)).
+			Dur("duration", r.bgsaveDuration).
+			Msg("Background save done")
+	}()
+	return nil
+}
+
+// InProgress reports whether a background save is running.
+func (r *RDB) InProgress() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.inProgress
+}
+
+// LastSave returns the time of the last successful save.
+func (r *RDB) LastSave() time.Time {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.lastSave
+}
+
+// AddChanges counts n changes of the data set.
+func (r *RDB) AddChanges(n int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.changes += n
+}
+
+// Changes returns the number of changes since the last successful save.
+func (r *RDB) Changes() int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.changes
+}
+
+// BackgroundSaveStatus reports how long the running background save has been
+// running, how long the last one took and how it ended. Durations are -1 if
+// there is no such save.
+func (r *RDB) BackgroundSaveStatus() (current, last time.Duration, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	current = -1
+	if r.inProgress {
+		current = time.Since(r.bgsaveStarted)
+	}
+	return current, r.bgsaveDuration, r.bgsaveErr
+}
+
+// DueSavePoint returns the first of points that is met at now: at least its
+// number of changes happened and its number of seconds passed since the last
+// save. After a failed background save, none is met for bgsaveRetryDelay.
+func (r *RDB) DueSavePoint(points []config.SavePoint, now time.Time) (config.SavePoint, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.inProgress || (r.bgsaveErr != nil && now.Sub(r.bgsaveStarted) < bgsaveRetryDelay) {
+		return config.SavePoint{}, false
+	}
+	for _, point := range points {
+		if r.changes >= int64(point.Changes) &&
+			now.Sub(r.lastSave) >= time.Duration(point.Seconds)*time.Second {
+			return point, true
+		}
+	}
+	return config.SavePoint{}, false
+}
+
+// saved records a successful save, which included the first changes changes.
+// A successful save also clears the error of the last background save.
+func (r *RDB) saved(changes int64) {
+	r.lastSave = time.Now()
+	r.changes -= changes
+	r.bgsaveErr = nil
+}
+
+func (r *RDB) write(entries []rdb.Entry) error {
+	r.fileMu.Lock()
+	defer r.fileMu.Unlock()
+	return writeFile(r.path, rdb.Encode(entries))
+}
+
+// writeFile replaces the file at path with data atomically: data is synced
+// to a temporary file in the same directory, which is then renamed.
+func writeFile(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "temp-*"+filepath.Ext(path))
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write temporary file: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync temporary file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close temporary file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to rename temporary file: %w", err)
+	}
+	return nil
+}
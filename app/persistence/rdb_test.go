@@ -0,0 +1,137 @@
+package persistence
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jorzel/myredis/app/config"
+	"github.com/jorzel/myredis/app/rdb"
+	"github.com/jorzel/myredis/app/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRDBSaveAndLoad(t *testing.T) {
+	dir := t.TempDir()
+	r := NewRDB(filepath.Join(dir, "dump.rdb"))
+	s := storage.NewStorage()
+	s.Set("key", &storage.KVRecord{Value: "value"})
+	before := r.LastSave()
+
+	require.NoError(t, r.Save(rdb.Collect(s)))
+
+	assert.False(t, r.LastSave().Before(before))
+	files, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Len(t, files, 1, "Expected no temporary file to be left")
+	assert.Equal(t, "dump.rdb", files[0].Name())
+
+	loaded := storage.NewStorage()
+	found, err := NewRDB(r.Path()).Load(loaded)
+	require.NoError(t, err)
+	assert.True(t, found)
+	record, err := loaded.Get("key")
+	require.NoError(t, err)
+	require.NotNil(t, record)
+	assert.Equal(t, "value", record.Value)
+}
+
+func TestRDBLoadMissingFile(t *testing.T) {
+	r := NewRDB(filepath.Join(t.TempDir(), "dump.rdb"))
+
+	found, err := r.Load(storage.NewStorage())
+
+	require.NoError(t, err)
+	assert.False(t, found)
+}
+
+func TestRDBLoadCorruptedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dump.rdb")
+	require.NoError(t, os.WriteFile(path, []byte("REDIS0011garbage"), 0o644))
+
+	_, err := NewRDB(path).Load(storage.NewStorage())
+
+	assert.Error(t, err)
+}
+
+func TestRDBBackgroundSave(t *testing.T) {
+	r := NewRDB(filepath.Join(t.TempDir(), "dump.rdb"))
+	entries := []rdb.Entry{{Key: "key", Record: &storage.KVRecord{Value: "value"}}}
+
+	// The file lock holds the first save back, so the second one overlaps it.
+	r.fileMu.Lock()
+	require.NoError(t, r.BackgroundSave(context.Background(), entries))
+	assert.True(t, r.InProgress())
+	assert.ErrorIs(t, r.BackgroundSave(context.Background(), entries), ErrSaveInProgress)
+	r.fileMu.Unlock()
+
+	require.Eventually(t, func() bool { return !r.InProgress() }, time.Second, time.Millisecond)
+	_, err := os.Stat(r.Path())
+	assert.NoError(t, err)
+}
+
+func TestRDBCountsChangesSinceLastSave(t *testing.T) {
+	r := NewRDB(filepath.Join(t.TempDir(), "dump.rdb"))
+	r.AddChanges(3)
+
+	// Changes counted while the save runs are not in the snapshot.
+	r.fileMu.Lock()
+	require.NoError(t, r.BackgroundSave(context.Background(), nil))
+	r.AddChanges(2)
+	r.fileMu.Unlock()
+
+	require.Eventually(t, func() bool { return !r.InProgress() }, time.Second, time.Millisecond)
+	assert.Equal(t, int64(2), r.Changes())
+	_, last, err := r.BackgroundSaveStatus()
+	assert.NoError(t, err)
+	assert.GreaterOrEqual(t, last, time.Duration(0))
+}
+
+func TestRDBDueSavePoint(t *testing.T) {
+	points := []config.SavePoint{{Seconds: 3600, Changes: 1}, {Seconds: 60, Changes: 100}}
+	tests := []struct {
+		name     string
+		changes  int64
+		elapsed  time.Duration
+		expected *config.SavePoint
+	}{
+		{name: "no changes", changes: 0, elapsed: 2 * time.Hour, expected: nil},
+		{name: "too early", changes: 50, elapsed: 2 * time.Minute, expected: nil},
+		{name: "enough changes", changes: 100, elapsed: 2 * time.Minute, expected: &points[1]},
+		{name: "enough time", changes: 1, elapsed: 2 * time.Hour, expected: &points[0]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRDB(filepath.Join(t.TempDir(), "dump.rdb"))
+			r.AddChanges(tt.changes)
+
+			point, due := r.DueSavePoint(points, r.LastSave().Add(tt.elapsed))
+
+			if tt.expected == nil {
+				assert.False(t, due)
+				return
+			}
+			require.True(t, due)
+			assert.Equal(t, *tt.expected, point)
+		})
+	}
+}
+
+func TestRDBDueSavePointAfterFailure(t *testing.T) {
+	r := NewRDB(filepath.Join(t.TempDir(), "missing", "dump.rdb"))
+	r.AddChanges(1)
+	points := []config.SavePoint{{Seconds: 1, Changes: 1}}
+	require.NoError(t, r.BackgroundSave(context.Background(), nil))
+	require.Eventually(t, func() bool { return !r.InProgress() }, time.Second, time.Millisecond)
+	_, _, err := r.BackgroundSaveStatus()
+	require.Error(t, err)
+	failedAt := r.bgsaveStarted
+
+	_, due := r.DueSavePoint(points, failedAt.Add(time.Second))
+	assert.False(t, due, "Expected no retry right after a failed save")
+	_, due = r.DueSavePoint(points, failedAt.Add(bgsaveRetryDelay))
+	assert.True(t, due)
+}
@@ -12,4 +12,5 @@ const (
 	REPLCONF   = "REPLCONF"
 	PSYNC      = "PSYNC"
 	FULLRESYNC = "FULLRESYNC"
+	CONTINUE   = "CONTINUE"
 )
@@ -0,0 +1,87 @@
+package protocol
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Error codes, the first word of an error reply. Clients branch on them.
+const (
+	ERR       = "ERR"
+	WRONGTYPE = "WRONGTYPE"
+	READONLY  = "READONLY"
+	NOPROTO   = "NOPROTO"
+	WRONGPASS = "WRONGPASS"
+	EXECABORT = "EXECABORT"
+	LOADING   = "LOADING"
+)
+
+// ErrorReply is an error reply, Code is its first word such as ERR or READONLY.
+// It is an error itself, so handlers return the same value to the client and
+// to the caller.
+type ErrorReply struct {
+	Code    string
+	Message string
+}
+
+func (r ErrorReply) AppendRESP(dst []byte, _ int) []byte {
+	dst = append(dst, '-')
+	dst = append(dst, r.Error()...)
+	return append(dst, CRLF...)
+}
+
+// Error returns the error as sent to the client, without the leading dash.
+func (r ErrorReply) Error() string {
+	return r.Code + " " + r.Message
+}
+
+// NewError builds a generic error reply with the ERR code.
+func NewError(message string) ErrorReply {
+	return ErrorReply{Code: ERR, Message: message}
+}
+
+// NewCodedError builds an error reply with a custom code.
+func NewCodedError(code, message string) ErrorReply {
+	return ErrorReply{Code: code, Message: message}
+}
+
+// Errors shared by many commands, worded as in Redis.
+var (
+	ErrSyntax      = NewError("syntax error")
+	ErrNotInteger  = NewError("value is not an integer or out of range")
+	ErrWrongType   = NewCodedError(WRONGTYPE, "Operation against a key holding the wrong kind of value")
+	ErrReadOnly    = NewCodedError(READONLY, "You can't write against a read only replica.")
+	ErrNoProto     = NewCodedError(NOPROTO, "unsupported protocol version")
+	ErrWrongPass   = NewCodedError(WRONGPASS, "invalid username-password pair or user is disabled.")
+	ErrNegativeTTL = NewError("timeout is negative")
+)
+
+// ErrWrongArity reports a command called with a wrong number of arguments.
+func ErrWrongArity(name string) ErrorReply {
+	return NewError(fmt.Sprintf("wrong number of arguments for '%s' command", strings.ToLower(name)))
+}
+
+// ErrUnknownCommand reports a command the server does not implement, quoting
+// the first arguments as Redis does.
+func ErrUnknownCommand(name string, args []string) ErrorReply {
+	var quoted strings.Builder
+	for _, arg := range args {
+		if quoted.Len()+len(arg) > 128 {
+			break
+		}
+		fmt.Fprintf(&quoted, "'%s' ", arg)
+	}
+	return NewError(fmt.Sprintf("unknown command '%s', with args beginning with: %s", name, quoted.String()))
+}
+
+// ErrUnknownSubcommand reports a subcommand the command does not implement.
+func ErrUnknownSubcommand(name, subcommand string) ErrorReply {
+	return NewError(fmt.Sprintf(
+		"unknown subcommand '%s'. Try %s HELP.", subcommand, strings.ToUpper(name),
+	))
+}
+
+// ErrInvalidExpire reports a non-positive expire time given to name.
+func ErrInvalidExpire(name string) ErrorReply {
+	return NewError(fmt.Sprintf("invalid expire time in '%s' command", strings.ToLower(name)))
+}
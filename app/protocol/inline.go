@@ -0,0 +1,172 @@
+package protocol
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// maxInlineLen limits the length of an inline command, and of the header
+// lines of RESP frames, as in Redis.
+const maxInlineLen = 64 * 1024
+
+// errLineTooLong is returned by readUntilLF for lines over maxInlineLen.
+var errLineTooLong = errors.New("line too long")
+
+// errUnbalancedQuotes is returned by SplitArgs for unterminated quotes.
+var errUnbalancedQuotes = errors.New("unbalanced quotes")
+
+// readInlineCommand reads a command typed as plain text, such as `SET a "b c"`.
+// Lines may end with LF alone, as sent by nc and telnet. Empty lines yield a
+// command with an empty name, which callers skip.
+func (p *StreamParser) readInlineCommand() (Command, error) {
+	line, err := p.readUntilLF()
+	if errors.Is(err, errLineTooLong) {
+		return Command{}, fmt.Errorf("%w: too big inline request", ErrProtocol)
+	}
+	if err != nil {
+		return Command{}, err
+	}
+
+	line = bytes.TrimSuffix(bytes.TrimSuffix(line, []byte("\n")), []byte("\r"))
+	args, err := SplitArgs(string(line))
+	if err != nil {
+		return Command{}, fmt.Errorf("%w: %v in request", ErrProtocol, err)
+	}
+	if len(args) == 0 {
+		return Command{}, nil
+	}
+	return NewCommand(args[0], args[1:]), nil
+}
+
+// readUntilLF reads up to and including the next LF. Lines longer than
+// maxInlineLen fail with errLineTooLong before they are read whole, so a
+// client that never sends a newline can't make the buffer grow unbounded.
+func (p *StreamParser) readUntilLF() ([]byte, error) {
+	var line []byte
+	for {
+		chunk, err := p.r.ReadSlice('\n')
+		p.consumed += len(chunk)
+		if p.raw != nil {
+			p.raw = append(p.raw, chunk...)
+		}
+		line = append(line, chunk...)
+		if len(line) > maxInlineLen {
+			return nil, errLineTooLong
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		return line, err
+	}
+}
+
+// SplitArgs splits line into arguments following the quoting rules of Redis
+// inline commands. Double quoted strings support \n, \r, \t, \b, \a and \xHH
+// escapes, single quoted strings only \'. A closing quote has to be followed
+// by a space or the end of the line.
+func SplitArgs(line string) ([]string, error) {
+	args := []string{}
+	i := 0
+	for {
+		for i < len(line) && isSpace(line[i]) {
+			i++
+		}
+		if i == len(line) {
+			return args, nil
+		}
+
+		var (
+			current        []byte
+			inDoubleQuotes bool
+			inSingleQuotes bool
+			done           bool
+		)
+		for !done {
+			if i == len(line) {
+				if inDoubleQuotes || inSingleQuotes {
+					return nil, errUnbalancedQuotes
+				}
+				break
+			}
+			c := line[i]
+			switch {
+			case inDoubleQuotes:
+				if c == '\\' && i+3 < len(line) && line[i+1] == 'x' && isHex(line[i+2]) && isHex(line[i+3]) {
+					b, _ := strconv.ParseUint(line[i+2:i+4], 16, 8)
+					current = append(current, byte(b))
+					i += 3
+				} else if c == '\\' && i+1 < len(line) {
+					i++
+					current = append(current, unescape(line[i]))
+				} else if c == '"' {
+					// The closing quote must be followed by a space or nothing.
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, errUnbalancedQuotes
+					}
+					done = true
+				} else {
+					current = append(current, c)
+				}
+			case inSingleQuotes:
+				if c == '\\' && i+1 < len(line) && line[i+1] == '\'' {
+					i++
+					current = append(current, '\'')
+				} else if c == '\'' {
+					if i+1 < len(line) && !isSpace(line[i+1]) {
+						return nil, errUnbalancedQuotes
+					}
+					done = true
+				} else {
+					current = append(current, c)
+				}
+			default:
+				switch c {
+				case ' ', '\n', '\r', '\t', 0:
+					done = true
+				case '"':
+					inDoubleQuotes = true
+				case '\'':
+					inSingleQuotes = true
+				default:
+					current = append(current, c)
+				}
+			}
+			if i < len(line) {
+				i++
+			}
+		}
+		args = append(args, string(current))
+	}
+}
+
+func unescape(c byte) byte {
+	switch c {
+	case 'n':
+		return '\n'
+	case 'r':
+		return '\r'
+	case 't':
+		return '\t'
+	case 'b':
+		return '\b'
+	case 'a':
+		return '\a'
+	default:
+		return c
+	}
+}
+
+func isSpace(c byte) bool {
+	switch c {
+	case ' ', '\t', '\n', '\v', '\f', '\r':
+		return true
+	}
+	return false
+}
+
+func isHex(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
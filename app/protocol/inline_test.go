@@ -0,0 +1,107 @@
+package protocol
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		name         string
+		line         string
+		expectedArgs []string
+		expectError  bool
+	}{
+		{
+			name:         "Plain words",
+			line:         "  SET key   value ",
+			expectedArgs: []string{"SET", "key", "value"},
+		},
+		{
+			name:         "Empty line",
+			line:         "   ",
+			expectedArgs: []string{},
+		},
+		{
+			name:         "Double quotes with escapes",
+			line:         `SET key "a\tb\n\"c\"\x41\x7a"`,
+			expectedArgs: []string{"SET", "key", "a\tb\n\"c\"Az"},
+		},
+		{
+			name:         "Invalid hex escape is kept literally",
+			line:         `"\xZZ"`,
+			expectedArgs: []string{"xZZ"},
+		},
+		{
+			name:         "Single quotes only unescape quotes",
+			line:         `ECHO 'it\'s \n'`,
+			expectedArgs: []string{"ECHO", `it's \n`},
+		},
+		{
+			name:         "Empty quoted argument",
+			line:         `SET key ""`,
+			expectedArgs: []string{"SET", "key", ""},
+		},
+		{
+			name:         "Quotes inside a word",
+			line:         `foo"bar baz"`,
+			expectedArgs: []string{"foobar baz"},
+		},
+		{
+			name:        "Unterminated double quotes",
+			line:        `SET key "value`,
+			expectError: true,
+		},
+		{
+			name:        "Unterminated single quotes",
+			line:        `SET key 'value`,
+			expectError: true,
+		},
+		{
+			name:        "Closing quote followed by a character",
+			line:        `SET key "value"x`,
+			expectError: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args, err := SplitArgs(tt.line)
+			if tt.expectError {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedArgs, args)
+		})
+	}
+}
+
+func TestStreamParserInlineErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		rawMessage string
+		errMsg     string
+	}{
+		{
+			name:       "Unbalanced quotes",
+			rawMessage: "SET key \"value\r\n",
+			errMsg:     "Protocol error: unbalanced quotes in request",
+		},
+		{
+			name:       "Too big inline request",
+			rawMessage: "SET key " + strings.Repeat("x", maxInlineLen) + "\r\n",
+			errMsg:     "Protocol error: too big inline request",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parser := NewStreamParser(strings.NewReader(tt.rawMessage), 0)
+			_, _, err := parser.ReadCommand()
+			require.ErrorIs(t, err, ErrProtocol)
+			assert.EqualError(t, err, tt.errMsg)
+		})
+	}
+}
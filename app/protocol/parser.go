@@ -3,18 +3,20 @@ package protocol
 import (
 	"bufio"
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
-	"slices"
+	"math"
 	"strconv"
 	"strings"
 )
 
-type CommandParser interface {
-	Parse(rawMessage []byte) (ParseResult, error)
-}
+// DefaultMaxBulkLen is the default limit of a single bulk string, as
+// proto-max-bulk-len in Redis.
+const DefaultMaxBulkLen = 512 * 1024 * 1024
 
-var writeCommnads = []string{SET}
+// ErrProtocol is wrapped by errors caused by malformed client input.
+var ErrProtocol = errors.New("Protocol error")
 
 type Command struct {
 	Name string
@@ -28,103 +30,133 @@ func NewCommand(name string, args []string) Command {
 	}
 }
 
-func (c Command) IsWrite() bool {
-	return slices.Contains(writeCommnads, c.Name)
+// Serialize encodes the command as a RESP array of bulk strings, the same
+// form clients use to send it.
+func (c Command) Serialize() []byte {
+	return BulkArray(append([]string{c.Name}, c.Args...))
 }
 
-// ParseResult holds either parsed commands or an RDB payload.
-type ParseResult struct {
-	Commands []Command
-	RDBDump  []byte // non-nil if the message contains an RDB bulk string
+// maxMultiBulkLen limits the number of arguments of a command, as in Redis.
+const maxMultiBulkLen = 1024 * 1024
+
+// StreamParser reads RESP frames from a connection. Frames split across
+// network reads are kept buffered until they are complete, so only whole
+// commands are returned.
+type StreamParser struct {
+	r          *bufio.Reader
+	maxBulkLen int
+	// consumed counts bytes read by the frame being parsed.
+	consumed int
+	// keepRaw makes ReadCommand keep the bytes of the command in raw.
+	keepRaw bool
+	raw     []byte
 }
 
-func NewCommandParser() CommandParser {
-	return DefaultCommandParser{}
+// NewStreamParser creates a parser reading from r. Bulk strings longer than
+// maxBulkLen are rejected, non-positive values mean DefaultMaxBulkLen.
+func NewStreamParser(r io.Reader, maxBulkLen int) *StreamParser {
+	reader, ok := r.(*bufio.Reader)
+	if !ok {
+		reader = bufio.NewReaderSize(r, 16*1024)
+	}
+	if maxBulkLen <= 0 {
+		maxBulkLen = DefaultMaxBulkLen
+	}
+	return &StreamParser{
+		r:          reader,
+		maxBulkLen: maxBulkLen,
+	}
 }
 
-// DefaultCommandParser parses raw RESP messages.
-type DefaultCommandParser struct{}
+// Buffered returns the number of bytes already received but not parsed yet.
+func (p *StreamParser) Buffered() int {
+	return p.r.Buffered()
+}
 
-// Parse parses rawMessage (RESP format) into commands or RDB payload.
-func (p DefaultCommandParser) Parse(rawMessage []byte) (ParseResult, error) {
-	result := ParseResult{}
+// KeepRaw makes the parser keep the bytes of every command it reads, exactly
+// as received, so a replica can relay its master's stream.
+func (p *StreamParser) KeepRaw() {
+	p.keepRaw = true
+}
 
-	reader := bufio.NewReader(bytes.NewReader(rawMessage))
+// Raw returns the bytes the last command took on the wire, including empty
+// inline lines before it. It is nil unless KeepRaw was called.
+func (p *StreamParser) Raw() []byte {
+	return p.raw
+}
 
+// ReadCommand blocks until a complete command is received, either a RESP array
+// or an inline command. Besides the command
+// it returns the number of bytes the command took on the wire. io.EOF is
+// returned only if the stream ended between commands.
+func (p *StreamParser) ReadCommand() (Command, int, error) {
+	p.consumed = 0
+	p.raw = nil
+	if p.keepRaw {
+		p.raw = []byte{}
+	}
 	for {
-		b, err := reader.Peek(1)
-		if err == io.EOF {
-			break // no more messages
-		}
+		b, err := p.r.Peek(1)
 		if err != nil {
-			return result, err
+			return Command{}, 0, err
 		}
 
-		switch b[0] {
-		case '*':
-			cmd, err := readCommand(reader)
-			if err != nil {
-				return result, err
-			}
-			result.Commands = append(result.Commands, cmd)
-		case '+':
-			cmd, err := readResponse(reader)
-			if err != nil {
-				return result, err
-			}
-			result.Commands = append(result.Commands, cmd)
-		case '$':
-			rdbPayload, err := readBulkRaw(reader)
-			if err != nil {
-				return result, err
-			}
-			// Store first RDB dump only
-			if len(result.RDBDump) == 0 {
-				result.RDBDump = rdbPayload
-			} else {
-				// If multiple bulk strings, you can extend here if needed
-			}
-
-		default:
-			return result, fmt.Errorf("unsupported RESP message start byte: %q", b[0])
+		var cmd Command
+		if b[0] == '*' {
+			cmd, err = p.readArrayCommand()
+		} else {
+			cmd, err = p.readInlineCommand()
+		}
+		if err != nil {
+			return Command{}, 0, unexpectedEOF(err)
+		}
+		if cmd.Name == "" {
+			continue // empty inline line
 		}
+		return cmd, p.consumed, nil
 	}
-
-	return result, nil
 }
 
-func readResponse(r *bufio.Reader) (Command, error) {
-	s, err := readSimpleString(r)
+// ReadResponse reads a simple string reply, such as +FULLRESYNC <replid> <offset>,
+// and returns it as a command with space separated arguments.
+func (p *StreamParser) ReadResponse() (Command, error) {
+	p.consumed = 0
+	p.raw = nil
+	line, err := p.readLine()
 	if err != nil {
 		return Command{}, fmt.Errorf("failed to read response: %w", err)
 	}
-	parts := strings.Split(s, " ")
+	if len(line) == 0 || line[0] != '+' {
+		return Command{}, fmt.Errorf("expected simple string starting with '+', got: %q", line)
+	}
+	parts := strings.Split(line[1:], " ")
 	if len(parts) == 1 {
 		return NewCommand(parts[0], nil), nil
 	}
 	return NewCommand(parts[0], parts[1:]), nil
 }
 
-func readCommand(r *bufio.Reader) (Command, error) {
-	line, err := readLine(r)
-
+// ReadRDB reads an RDB payload, which is sent as a bulk string without the
+// trailing CRLF.
+func (p *StreamParser) ReadRDB() ([]byte, error) {
+	p.consumed = 0
+	p.raw = nil
+	length, err := p.readLength('$', math.MaxInt)
 	if err != nil {
-		return Command{}, err
-	}
-
-	if len(line) == 0 || line[0] != '*' {
-		return Command{}, fmt.Errorf("expected array, got: %q", line)
+		return nil, err
 	}
+	return p.readN(length)
+}
 
-	argCount, err := strconv.Atoi(line[1:])
+func (p *StreamParser) readArrayCommand() (Command, error) {
+	argCount, err := p.readLength('*', maxMultiBulkLen)
 	if err != nil {
-		return Command{}, fmt.Errorf("invalid array length: %w", err)
+		return Command{}, err
 	}
 
-	args := make([]string, 0, argCount)
+	args := make([]string, 0, min(argCount, 1024))
 	for i := 0; i < argCount; i++ {
-		arg, err := readBulkString(r)
-
+		arg, err := p.readBulkString()
 		if err != nil {
 			return Command{}, err
 		}
@@ -132,82 +164,89 @@ func readCommand(r *bufio.Reader) (Command, error) {
 	}
 
 	if len(args) == 0 {
-		return Command{}, fmt.Errorf("empty command")
+		return Command{}, fmt.Errorf("%w: empty command", ErrProtocol)
 	}
 
 	return NewCommand(args[0], args[1:]), nil
 }
 
-func readSimpleString(r *bufio.Reader) (string, error) {
-	line, err := readLine(r)
-	if err != nil {
-		return "", fmt.Errorf("failed to read simple string: %w", err)
-	}
-	if len(line) == 0 || line[0] != '+' {
-		return "", fmt.Errorf("expected simple string starting with '+', got: %q", line)
-	}
-	return line[1:], nil
-}
-
-func readBulkString(r *bufio.Reader) (string, error) {
-	line, err := readLine(r)
+func (p *StreamParser) readBulkString() (string, error) {
+	length, err := p.readLength('$', p.maxBulkLen)
 	if err != nil {
 		return "", err
 	}
-	if len(line) == 0 || line[0] != '$' {
-		return "", fmt.Errorf("expected bulk string, got: %q", line)
-	}
-
-	length, err := strconv.Atoi(line[1:])
+	buf, err := p.readN(length)
 	if err != nil {
-		return "", fmt.Errorf("invalid bulk length: %w", err)
-	}
-
-	buf := make([]byte, length)
-	if _, err := io.ReadFull(r, buf); err != nil {
 		return "", err
 	}
 
-	crlf := make([]byte, 2)
-	if _, err := io.ReadFull(r, crlf); err != nil {
+	crlf, err := p.readN(2)
+	if err != nil {
 		return "", err
 	}
 	if !bytes.Equal(crlf, []byte(CRLF)) {
-		return "", fmt.Errorf("expected CRLF after bulk string, got: %q", crlf)
+		return "", fmt.Errorf("%w: expected CRLF after bulk string, got: %q", ErrProtocol, crlf)
 	}
 
 	return string(buf), nil
 }
 
-func readBulkRaw(r *bufio.Reader) ([]byte, error) {
-	line, err := readLine(r)
+// readLength reads a "<prefix><length>" line and validates the length.
+func (p *StreamParser) readLength(prefix byte, limit int) (int, error) {
+	kind := "bulk"
+	if prefix == '*' {
+		kind = "multibulk"
+	}
+	line, err := p.readLine()
+	if errors.Is(err, errLineTooLong) {
+		if prefix == '*' {
+			return 0, fmt.Errorf("%w: too big mbulk count string", ErrProtocol)
+		}
+		return 0, fmt.Errorf("%w: too big bulk count string", ErrProtocol)
+	}
 	if err != nil {
-		return nil, err
+		return 0, err
 	}
-	if len(line) == 0 || line[0] != '$' {
-		return nil, fmt.Errorf("expected bulk string, got: %q", line)
+	if len(line) == 0 || line[0] != prefix {
+		return 0, fmt.Errorf("%w: expected '%c', got: %q", ErrProtocol, prefix, line)
 	}
-
 	length, err := strconv.Atoi(line[1:])
-	if err != nil {
-		return nil, fmt.Errorf("invalid bulk length: %w", err)
+	if err != nil || length < 0 || length > limit {
+		return 0, fmt.Errorf("%w: invalid %s length", ErrProtocol, kind)
 	}
+	return length, nil
+}
 
-	buf := make([]byte, length)
-	if _, err := io.ReadFull(r, buf); err != nil {
+// readN reads exactly n bytes, growing the buffer as data arrives, so a
+// declared length alone does not allocate it upfront.
+func (p *StreamParser) readN(n int) ([]byte, error) {
+	var buf bytes.Buffer
+	copied, err := io.CopyN(&buf, p.r, int64(n))
+	p.consumed += int(copied)
+	if p.raw != nil {
+		p.raw = append(p.raw, buf.Bytes()...)
+	}
+	if err != nil {
 		return nil, err
 	}
-
-	return buf, nil
+	return buf.Bytes(), nil
 }
 
-func readLine(r *bufio.Reader) (string, error) {
-	line, err := r.ReadString('\n')
+func (p *StreamParser) readLine() (string, error) {
+	line, err := p.readUntilLF()
 	if err != nil {
 		return "", err
 	}
 	if len(line) < 2 || line[len(line)-2] != '\r' {
-		return "", fmt.Errorf("protocol error: expected CRLF ending, got %q", line)
+		return "", fmt.Errorf("%w: expected CRLF ending, got %q", ErrProtocol, line)
+	}
+	return string(line[:len(line)-2]), nil // strip \r\n
+}
+
+// unexpectedEOF reports a stream that ended in the middle of a frame.
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
 	}
-	return line[:len(line)-2], nil // strip \r\n
+	return err
 }
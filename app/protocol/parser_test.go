@@ -1,13 +1,17 @@
 package protocol
 
 import (
+	"bytes"
+	"io"
+	"strings"
 	"testing"
+	"testing/iotest"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
-func TestParser(t *testing.T) {
+func TestStreamParserReadCommand(t *testing.T) {
 	// test cases for command parsing
 	tests := []struct {
 		name             string
@@ -83,40 +87,195 @@ func TestParser(t *testing.T) {
 			},
 		},
 		{
-			name:             "DB file",
-			rawMessage:       []byte("$9\r\nREDIS0011"),
-			expectedCommands: []Command{},
-		},
-		{
-			name:       "Db file + REPLCONF GETACK",
-			rawMessage: []byte("$9\r\nREDIS0011*3\r\n$8\r\nREPLCONF\r\n$6\r\nGETACK\r\n$1\r\n*\r\n"),
+			name:       "Inline command",
+			rawMessage: []byte("set key \"hello world\"\r\n"),
 			expectedCommands: []Command{
 				{
-					Name: "REPLCONF",
-					Args: []string{"GETACK", "*"},
+					Name: "SET",
+					Args: []string{"key", "hello world"},
 				},
 			},
 		},
 		{
-			name:       "FULLRESYNC command",
-			rawMessage: []byte("+FULLRESYNC repl-id 0\r\n"),
+			name:       "Inline commands ending with LF and empty lines",
+			rawMessage: []byte("PING\n\r\n\nECHO 'it\\'s'\n"),
 			expectedCommands: []Command{
 				{
-					Name: "FULLRESYNC",
-					Args: []string{"repl-id", "0"},
+					Name: "PING",
+					Args: []string{},
+				},
+				{
+					Name: "ECHO",
+					Args: []string{"it's"},
 				},
 			},
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			parser := NewCommandParser()
-			result, err := parser.Parse(tt.rawMessage)
-			require.NoError(t, err, "Expected no error parsing command")
-			require.Equal(t, len(tt.expectedCommands), len(result.Commands), "Expected number of commands to match")
-			for i := range result.Commands {
-				assert.Equal(t, tt.expectedCommands[i].Name, result.Commands[i].Name, "Command name mismatch")
-				assert.Equal(t, tt.expectedCommands[i].Args, result.Commands[i].Args, "Command arguments mismatch")
+			parser := NewStreamParser(bytes.NewReader(tt.rawMessage), 0)
+			var commands []Command
+			for {
+				command, _, err := parser.ReadCommand()
+				if err == io.EOF {
+					break
+				}
+				require.NoError(t, err, "Expected no error parsing command")
+				commands = append(commands, command)
+			}
+			require.Equal(t, len(tt.expectedCommands), len(commands), "Expected number of commands to match")
+			for i := range commands {
+				assert.Equal(t, tt.expectedCommands[i].Name, commands[i].Name, "Command name mismatch")
+				assert.Equal(t, tt.expectedCommands[i].Args, commands[i].Args, "Command arguments mismatch")
+			}
+		})
+	}
+}
+
+func TestStreamParserReadsFullResync(t *testing.T) {
+	raw := "+FULLRESYNC repl-id 0\r\n$9\r\nREDIS0011*3\r\n$8\r\nREPLCONF\r\n$6\r\nGETACK\r\n$1\r\n*\r\n"
+	parser := NewStreamParser(strings.NewReader(raw), 0)
+
+	reply, err := parser.ReadResponse()
+	require.NoError(t, err)
+	assert.Equal(t, "FULLRESYNC", reply.Name)
+	assert.Equal(t, []string{"repl-id", "0"}, reply.Args)
+
+	dump, err := parser.ReadRDB()
+	require.NoError(t, err)
+	assert.Equal(t, "REDIS0011", string(dump), "RDB payload has no trailing CRLF")
+
+	command, _, err := parser.ReadCommand()
+	require.NoError(t, err)
+	assert.Equal(t, "REPLCONF", command.Name)
+	assert.Equal(t, []string{"GETACK", "*"}, command.Args)
+}
+
+func TestStreamParserReadsCommandsSplitAcrossReads(t *testing.T) {
+	raw := "*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$5\r\nvalue\r\n*1\r\n$4\r\nPING\r\n"
+	// Every read returns a single byte, so each frame arrives in many pieces.
+	parser := NewStreamParser(iotest.OneByteReader(strings.NewReader(raw)), 0)
+
+	command, size, err := parser.ReadCommand()
+	require.NoError(t, err)
+	assert.Equal(t, "SET", command.Name)
+	assert.Equal(t, []string{"key", "value"}, command.Args)
+	assert.Equal(t, 33, size)
+
+	command, size, err = parser.ReadCommand()
+	require.NoError(t, err)
+	assert.Equal(t, "PING", command.Name)
+	assert.Equal(t, 14, size)
+
+	_, _, err = parser.ReadCommand()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestStreamParserKeepsRawCommands(t *testing.T) {
+	frames := []string{
+		"*2\r\n$4\r\nECHO\r\n$5\r\nhello\r\n",
+		"\r\n\nPING\n",
+		"SET key \"a b\"\r\n",
+		"*3\r\n$8\r\nREPLCONF\r\n$6\r\nGETACK\r\n$1\r\n*\r\n",
+	}
+	parser := NewStreamParser(iotest.OneByteReader(strings.NewReader(strings.Join(frames, ""))), 0)
+	parser.KeepRaw()
+
+	for _, frame := range frames {
+		_, size, err := parser.ReadCommand()
+		require.NoError(t, err)
+		assert.Equal(t, frame, string(parser.Raw()))
+		assert.Equal(t, len(frame), size)
+	}
+}
+
+func TestStreamParserReadsLargeBulkString(t *testing.T) {
+	value := strings.Repeat("x", 64*1024)
+	raw := BulkArray([]string{"SET", "key", value})
+	parser := NewStreamParser(bytes.NewReader(raw), 0)
+
+	command, size, err := parser.ReadCommand()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"key", value}, command.Args)
+	assert.Equal(t, len(raw), size)
+}
+
+// endless reads as an infinite stream of the same byte.
+type endless byte
+
+func (e endless) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = byte(e)
+	}
+	return len(p), nil
+}
+
+func TestStreamParserStopsOnEndlessHeader(t *testing.T) {
+	parser := NewStreamParser(io.MultiReader(strings.NewReader("*"), endless('1')), 0)
+
+	_, size, err := parser.ReadCommand()
+	require.ErrorIs(t, err, ErrProtocol)
+	assert.Zero(t, size)
+	assert.LessOrEqual(t, parser.consumed, 2*maxInlineLen, "header read only up to the limit")
+}
+
+func TestStreamParserErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		rawMessage string
+		maxBulkLen int
+		err        error
+		errMsg     string
+	}{
+		{
+			name:       "Bulk string longer than the limit",
+			rawMessage: "*2\r\n$4\r\nECHO\r\n$6\r\nlonger\r\n",
+			maxBulkLen: 5,
+			err:        ErrProtocol,
+			errMsg:     "Protocol error: invalid bulk length",
+		},
+		{
+			name:       "Negative multibulk length",
+			rawMessage: "*-3\r\n",
+			err:        ErrProtocol,
+			errMsg:     "Protocol error: invalid multibulk length",
+		},
+		{
+			name:       "Multibulk length over the limit",
+			rawMessage: "*1048577\r\n",
+			err:        ErrProtocol,
+			errMsg:     "Protocol error: invalid multibulk length",
+		},
+		{
+			name:       "Multibulk length line without an end",
+			rawMessage: "*" + strings.Repeat("1", 70*1024),
+			err:        ErrProtocol,
+			errMsg:     "Protocol error: too big mbulk count string",
+		},
+		{
+			name:       "Bulk length line without an end",
+			rawMessage: "*1\r\n$" + strings.Repeat("1", 70*1024),
+			err:        ErrProtocol,
+			errMsg:     "Protocol error: too big bulk count string",
+		},
+		{
+			name:       "Missing CRLF after bulk string",
+			rawMessage: "*1\r\n$4\r\nPINGxx",
+			err:        ErrProtocol,
+		},
+		{
+			name:       "Stream ends in the middle of a command",
+			rawMessage: "*2\r\n$4\r\nECHO\r\n$5\r\nhel",
+			err:        io.ErrUnexpectedEOF,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parser := NewStreamParser(strings.NewReader(tt.rawMessage), tt.maxBulkLen)
+			_, _, err := parser.ReadCommand()
+			require.ErrorIs(t, err, tt.err)
+			if tt.errMsg != "" {
+				assert.EqualError(t, err, tt.errMsg)
 			}
 		})
 	}
@@ -0,0 +1,223 @@
+package protocol
+
+import (
+	"math"
+	"strconv"
+)
+
+// Reply is a value sent back to a client. It is serialized according to the
+// protocol version of the connection, RESP3 only types fall back to their
+// closest RESP2 equivalent.
+type Reply interface {
+	// AppendRESP appends the serialized reply to dst and returns the extended buffer.
+	AppendRESP(dst []byte, version int) []byte
+}
+
+// SimpleStringReply is a short, binary unsafe status reply such as OK.
+type SimpleStringReply string
+
+func (r SimpleStringReply) AppendRESP(dst []byte, _ int) []byte {
+	dst = append(dst, '+')
+	dst = append(dst, r...)
+	return append(dst, CRLF...)
+}
+
+// IntegerReply is a signed 64 bit integer.
+type IntegerReply int64
+
+func (r IntegerReply) AppendRESP(dst []byte, _ int) []byte {
+	dst = append(dst, ':')
+	dst = strconv.AppendInt(dst, int64(r), 10)
+	return append(dst, CRLF...)
+}
+
+// BulkStringReply is a binary safe string.
+type BulkStringReply string
+
+func (r BulkStringReply) AppendRESP(dst []byte, _ int) []byte {
+	dst = append(dst, '$')
+	dst = strconv.AppendInt(dst, int64(len(r)), 10)
+	dst = append(dst, CRLF...)
+	dst = append(dst, r...)
+	return append(dst, CRLF...)
+}
+
+// NullReply is a missing value. RESP2 sends it as a nil bulk string.
+type NullReply struct{}
+
+func (NullReply) AppendRESP(dst []byte, version int) []byte {
+	if version == RESP3 {
+		return append(dst, "_"+CRLF...)
+	}
+	return append(dst, "$-1"+CRLF...)
+}
+
+// NullArrayReply is a missing aggregate. RESP2 sends it as a nil array.
+type NullArrayReply struct{}
+
+func (NullArrayReply) AppendRESP(dst []byte, version int) []byte {
+	if version == RESP3 {
+		return append(dst, "_"+CRLF...)
+	}
+	return append(dst, "*-1"+CRLF...)
+}
+
+// ArrayReply is an ordered collection of replies of any type.
+type ArrayReply []Reply
+
+func (r ArrayReply) AppendRESP(dst []byte, version int) []byte {
+	return appendAggregate(dst, '*', len(r), r, version)
+}
+
+// SetReply is an unordered collection of unique replies. RESP2 sends it as an array.
+type SetReply []Reply
+
+func (r SetReply) AppendRESP(dst []byte, version int) []byte {
+	prefix := byte('~')
+	if version != RESP3 {
+		prefix = '*'
+	}
+	return appendAggregate(dst, prefix, len(r), r, version)
+}
+
+// PushReply is an out of band message, such as a pub/sub one. RESP2 sends it
+// as an array.
+type PushReply []Reply
+
+func (r PushReply) AppendRESP(dst []byte, version int) []byte {
+	prefix := byte('>')
+	if version != RESP3 {
+		prefix = '*'
+	}
+	return appendAggregate(dst, prefix, len(r), r, version)
+}
+
+// KeyValue is a single entry of a MapReply.
+type KeyValue struct {
+	Key   Reply
+	Value Reply
+}
+
+// MapReply is an ordered collection of key and value pairs. RESP2 sends it
+// as a flat array of keys followed by their values.
+type MapReply []KeyValue
+
+func (r MapReply) AppendRESP(dst []byte, version int) []byte {
+	if version == RESP3 {
+		dst = appendHeader(dst, '%', len(r))
+	} else {
+		dst = appendHeader(dst, '*', 2*len(r))
+	}
+	for _, kv := range r {
+		dst = kv.Key.AppendRESP(dst, version)
+		dst = kv.Value.AppendRESP(dst, version)
+	}
+	return dst
+}
+
+// BooleanReply is a boolean. RESP2 sends it as integer 1 or 0.
+type BooleanReply bool
+
+func (r BooleanReply) AppendRESP(dst []byte, version int) []byte {
+	if version != RESP3 {
+		if r {
+			return IntegerReply(1).AppendRESP(dst, version)
+		}
+		return IntegerReply(0).AppendRESP(dst, version)
+	}
+	if r {
+		return append(dst, "#t"+CRLF...)
+	}
+	return append(dst, "#f"+CRLF...)
+}
+
+// DoubleReply is a floating point number. RESP2 sends it as a bulk string.
+type DoubleReply float64
+
+func (r DoubleReply) AppendRESP(dst []byte, version int) []byte {
+	var s string
+	f := float64(r)
+	switch {
+	case math.IsInf(f, 1):
+		s = "inf"
+	case math.IsInf(f, -1):
+		s = "-inf"
+	case math.IsNaN(f):
+		s = "nan"
+	default:
+		s = strconv.FormatFloat(f, 'g', 17, 64)
+	}
+	if version != RESP3 {
+		return BulkStringReply(s).AppendRESP(dst, version)
+	}
+	dst = append(dst, ',')
+	dst = append(dst, s...)
+	return append(dst, CRLF...)
+}
+
+// BigNumberReply is an integer of arbitrary size given as its decimal
+// representation. RESP2 sends it as a bulk string.
+type BigNumberReply string
+
+func (r BigNumberReply) AppendRESP(dst []byte, version int) []byte {
+	if version != RESP3 {
+		return BulkStringReply(r).AppendRESP(dst, version)
+	}
+	dst = append(dst, '(')
+	dst = append(dst, r...)
+	return append(dst, CRLF...)
+}
+
+// VerbatimReply is a string with a three characters long format, such as
+// "txt" or "mkd". RESP2 sends only the text as a bulk string.
+type VerbatimReply struct {
+	Format string
+	Text   string
+}
+
+func (r VerbatimReply) AppendRESP(dst []byte, version int) []byte {
+	if version != RESP3 {
+		return BulkStringReply(r.Text).AppendRESP(dst, version)
+	}
+	dst = append(dst, '=')
+	dst = strconv.AppendInt(dst, int64(len(r.Format)+1+len(r.Text)), 10)
+	dst = append(dst, CRLF...)
+	dst = append(dst, r.Format...)
+	dst = append(dst, ':')
+	dst = append(dst, r.Text...)
+	return append(dst, CRLF...)
+}
+
+// RawReply is sent as is, regardless of the protocol version. It carries
+// payloads that are not RESP values, such as an RDB transfer.
+type RawReply []byte
+
+func (r RawReply) AppendRESP(dst []byte, _ int) []byte {
+	return append(dst, r...)
+}
+
+// OK is the most common simple string reply.
+var OK = SimpleStringReply("OK")
+
+// BulkStrings builds an array of bulk strings.
+func BulkStrings(elements ...string) ArrayReply {
+	reply := make(ArrayReply, len(elements))
+	for i, element := range elements {
+		reply[i] = BulkStringReply(element)
+	}
+	return reply
+}
+
+func appendHeader(dst []byte, prefix byte, count int) []byte {
+	dst = append(dst, prefix)
+	dst = strconv.AppendInt(dst, int64(count), 10)
+	return append(dst, CRLF...)
+}
+
+func appendAggregate(dst []byte, prefix byte, count int, elements []Reply, version int) []byte {
+	dst = appendHeader(dst, prefix, count)
+	for _, element := range elements {
+		dst = element.AppendRESP(dst, version)
+	}
+	return dst
+}
@@ -0,0 +1,152 @@
+package protocol
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplySerialization(t *testing.T) {
+	tests := []struct {
+		name  string
+		reply Reply
+		resp2 string
+		resp3 string
+	}{
+		{
+			name:  "Simple string",
+			reply: OK,
+			resp2: "+OK\r\n",
+			resp3: "+OK\r\n",
+		},
+		{
+			name:  "Error",
+			reply: NewCodedError("READONLY", "read only"),
+			resp2: "-READONLY read only\r\n",
+			resp3: "-READONLY read only\r\n",
+		},
+		{
+			name:  "Integer",
+			reply: IntegerReply(-42),
+			resp2: ":-42\r\n",
+			resp3: ":-42\r\n",
+		},
+		{
+			name:  "Bulk string",
+			reply: BulkStringReply("hello"),
+			resp2: "$5\r\nhello\r\n",
+			resp3: "$5\r\nhello\r\n",
+		},
+		{
+			name:  "Null",
+			reply: NullReply{},
+			resp2: "$-1\r\n",
+			resp3: "_\r\n",
+		},
+		{
+			name:  "Null array",
+			reply: NullArrayReply{},
+			resp2: "*-1\r\n",
+			resp3: "_\r\n",
+		},
+		{
+			name: "Nested mixed array",
+			reply: ArrayReply{
+				BulkStringReply("0"),
+				ArrayReply{IntegerReply(1), BulkStrings("a", "b"), NullReply{}},
+			},
+			resp2: "*2\r\n$1\r\n0\r\n*3\r\n:1\r\n*2\r\n$1\r\na\r\n$1\r\nb\r\n$-1\r\n",
+			resp3: "*2\r\n$1\r\n0\r\n*3\r\n:1\r\n*2\r\n$1\r\na\r\n$1\r\nb\r\n_\r\n",
+		},
+		{
+			name: "Map",
+			reply: MapReply{
+				{Key: BulkStringReply("a"), Value: IntegerReply(1)},
+				{Key: BulkStringReply("b"), Value: BooleanReply(true)},
+			},
+			resp2: "*4\r\n$1\r\na\r\n:1\r\n$1\r\nb\r\n:1\r\n",
+			resp3: "%2\r\n$1\r\na\r\n:1\r\n$1\r\nb\r\n#t\r\n",
+		},
+		{
+			name:  "Set",
+			reply: SetReply{BulkStringReply("a")},
+			resp2: "*1\r\n$1\r\na\r\n",
+			resp3: "~1\r\n$1\r\na\r\n",
+		},
+		{
+			name:  "Push",
+			reply: PushReply(BulkStrings("message", "ch", "hi")),
+			resp2: "*3\r\n$7\r\nmessage\r\n$2\r\nch\r\n$2\r\nhi\r\n",
+			resp3: ">3\r\n$7\r\nmessage\r\n$2\r\nch\r\n$2\r\nhi\r\n",
+		},
+		{
+			name:  "Boolean false",
+			reply: BooleanReply(false),
+			resp2: ":0\r\n",
+			resp3: "#f\r\n",
+		},
+		{
+			name:  "Double",
+			reply: DoubleReply(1.5),
+			resp2: "$3\r\n1.5\r\n",
+			resp3: ",1.5\r\n",
+		},
+		{
+			name:  "Double infinity",
+			reply: DoubleReply(math.Inf(-1)),
+			resp2: "$4\r\n-inf\r\n",
+			resp3: ",-inf\r\n",
+		},
+		{
+			name:  "Big number",
+			reply: BigNumberReply("3492890328409238509324850943850943825024385"),
+			resp2: "$43\r\n3492890328409238509324850943850943825024385\r\n",
+			resp3: "(3492890328409238509324850943850943825024385\r\n",
+		},
+		{
+			name:  "Verbatim string",
+			reply: VerbatimReply{Format: "txt", Text: "Some string"},
+			resp2: "$11\r\nSome string\r\n",
+			resp3: "=15\r\ntxt:Some string\r\n",
+		},
+		{
+			name:  "Raw",
+			reply: RawReply("$3\r\nabc"),
+			resp2: "$3\r\nabc",
+			resp3: "$3\r\nabc",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.resp2, string(tt.reply.AppendRESP(nil, RESP2)))
+			assert.Equal(t, tt.resp3, string(tt.reply.AppendRESP(nil, RESP3)))
+		})
+	}
+}
+
+func TestWriterBuffersRepliesUntilFlush(t *testing.T) {
+	var out bytes.Buffer
+	writer := NewWriter(&out)
+
+	require.NoError(t, writer.WriteReply(OK))
+	writer.SetVersion(RESP3)
+	require.NoError(t, writer.WriteReply(NullReply{}))
+	assert.Empty(t, out.String())
+
+	require.NoError(t, writer.Flush())
+	assert.Equal(t, "+OK\r\n_\r\n", out.String())
+}
+
+func TestWriterWritesRawBytesAfterBufferedReplies(t *testing.T) {
+	var out bytes.Buffer
+	writer := NewWriter(&out)
+
+	require.NoError(t, writer.WriteReply(SimpleStringReply("FULLRESYNC id 0")))
+	_, err := writer.Write([]byte("*1\r\n$4\r\nPING\r\n"))
+	require.NoError(t, err)
+
+	assert.Equal(t, "+FULLRESYNC id 0\r\n*1\r\n$4\r\nPING\r\n", out.String())
+}
@@ -0,0 +1,70 @@
+package protocol
+
+import (
+	"bufio"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// Protocol versions a client can negotiate with HELLO.
+const (
+	RESP2 = 2
+	RESP3 = 3
+)
+
+// Writer buffers replies to a connection until they are flushed, so replies
+// to pipelined commands leave in as few writes as possible. Replies are
+// serialized in the protocol version set on the writer.
+type Writer struct {
+	mu      sync.Mutex
+	w       *bufio.Writer
+	buf     []byte
+	version atomic.Int32
+}
+
+// NewWriter creates a RESP2 writer on top of w.
+func NewWriter(w io.Writer) *Writer {
+	writer := &Writer{
+		w: bufio.NewWriterSize(w, 16*1024),
+	}
+	writer.version.Store(RESP2)
+	return writer
+}
+
+// Version returns the protocol version replies are serialized in.
+func (w *Writer) Version() int {
+	return int(w.version.Load())
+}
+
+// SetVersion changes the protocol version of the following replies.
+func (w *Writer) SetVersion(version int) {
+	w.version.Store(int32(version))
+}
+
+// WriteReply serializes reply into the buffer.
+func (w *Writer) WriteReply(reply Reply) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buf = reply.AppendRESP(w.buf[:0], w.Version())
+	_, err := w.w.Write(w.buf)
+	return err
+}
+
+// Write sends already serialized bytes right away, after any buffered replies.
+func (w *Writer) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	n, err := w.w.Write(b)
+	if err != nil {
+		return n, err
+	}
+	return n, w.w.Flush()
+}
+
+// Flush sends all buffered replies.
+func (w *Writer) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.w.Flush()
+}
@@ -0,0 +1,32 @@
+package rdb
+
+// crc64Poly is the reflected form of the CRC-64-Jones polynomial
+// (0xad93d23594c935a9) used by Redis for RDB checksums.
+const crc64Poly = 0x95ac9329ac4bc9b5
+
+var crc64Table = makeCRC64Table()
+
+func makeCRC64Table() [256]uint64 {
+	var table [256]uint64
+	for i := range table {
+		crc := uint64(i)
+		for j := 0; j < 8; j++ {
+			if crc&1 == 1 {
+				crc = crc>>1 ^ crc64Poly
+			} else {
+				crc >>= 1
+			}
+		}
+		table[i] = crc
+	}
+	return table
+}
+
+// crc64 updates crc with p. Unlike hash/crc64, Redis neither inverts the
+// initial value nor the result.
+func crc64(crc uint64, p []byte) uint64 {
+	for _, b := range p {
+		crc = crc64Table[byte(crc)^b] ^ crc>>8
+	}
+	return crc
+}
@@ -0,0 +1,379 @@
+package rdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/jorzel/myredis/app/storage"
+)
+
+const (
+	magic = "REDIS"
+	// maxVersion is the newest RDB format version the decoder understands.
+	maxVersion = 12
+)
+
+// Opcodes and value types of the RDB format.
+// https://rdb.fnordig.de/file_format.html
+const (
+	opFunction2    = 0xF5
+	opModuleAux    = 0xF7
+	opIdle         = 0xF8
+	opFreq         = 0xF9
+	opAux          = 0xFA
+	opResizeDB     = 0xFB
+	opExpireTimeMs = 0xFC
+	opExpireTime   = 0xFD
+	opSelectDB     = 0xFE
+	opEOF          = 0xFF
+
+	typeString = 0
+)
+
+// Special string encodings signalled by the 0b11 length prefix.
+const (
+	encInt8  = 0
+	encInt16 = 1
+	encInt32 = 2
+	encLZF   = 3
+)
+
+// Entry is a single key loaded from an RDB file.
+type Entry struct {
+	Key    string
+	Record *storage.KVRecord
+}
+
+// Snapshot is the decoded content of an RDB file.
+type Snapshot struct {
+	Version int
+	Aux     map[string]string
+	Entries []Entry
+}
+
+// Decode parses an RDB payload. The trailing checksum is verified unless it
+// is zero, which means checksums were disabled by the producer.
+func Decode(data []byte) (*Snapshot, error) {
+	d := &decoder{
+		r: bytes.NewReader(data),
+	}
+	snapshot, err := d.decode()
+	if err != nil {
+		return nil, err
+	}
+	if snapshot.Version >= 5 {
+		if err := verifyChecksum(data); err != nil {
+			return nil, err
+		}
+	}
+	return snapshot, nil
+}
+
+// Load decodes an RDB payload and replaces the content of s with it.
+// Keys that are already expired are skipped.
+func Load(data []byte, s storage.Storage) error {
+	snapshot, err := Decode(data)
+	if err != nil {
+		return err
+	}
+	if err := s.Flush(); err != nil {
+		return fmt.Errorf("failed to flush storage: %w", err)
+	}
+	now := time.Now()
+	for _, entry := range snapshot.Entries {
+		if entry.Record.ExpireAt != nil && entry.Record.ExpireAt.Before(now) {
+			continue
+		}
+		if err := s.Set(entry.Key, entry.Record); err != nil {
+			return fmt.Errorf("failed to store key %s: %w", entry.Key, err)
+		}
+	}
+	return nil
+}
+
+func verifyChecksum(data []byte) error {
+	if len(data) < 8 {
+		return fmt.Errorf("rdb payload too short to contain a checksum")
+	}
+	body, footer := data[:len(data)-8], data[len(data)-8:]
+	expected := binary.LittleEndian.Uint64(footer)
+	if expected == 0 {
+		return nil
+	}
+	if actual := crc64(0, body); actual != expected {
+		return fmt.Errorf("rdb checksum mismatch: expected %x, got %x", expected, actual)
+	}
+	return nil
+}
+
+type decoder struct {
+	r *bytes.Reader
+}
+
+func (d *decoder) decode() (*Snapshot, error) {
+	header := make([]byte, len(magic)+4)
+	if _, err := io.ReadFull(d.r, header); err != nil {
+		return nil, fmt.Errorf("failed to read rdb header: %w", err)
+	}
+	if string(header[:len(magic)]) != magic {
+		return nil, fmt.Errorf("invalid rdb header: %q", header)
+	}
+	version, err := strconv.Atoi(string(header[len(magic):]))
+	if err != nil || version < 1 || version > maxVersion {
+		return nil, fmt.Errorf("unsupported rdb version: %q", header[len(magic):])
+	}
+
+	snapshot := &Snapshot{
+		Version: version,
+		Aux:     map[string]string{},
+	}
+	var expireAt *time.Time
+	for {
+		opcode, err := d.r.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read rdb opcode: %w", err)
+		}
+
+		switch opcode {
+		case opEOF:
+			return snapshot, nil
+		case opAux:
+			key, err := d.readString()
+			if err != nil {
+				return nil, fmt.Errorf("failed to read aux field key: %w", err)
+			}
+			value, err := d.readString()
+			if err != nil {
+				return nil, fmt.Errorf("failed to read aux field %s: %w", key, err)
+			}
+			snapshot.Aux[key] = value
+		case opSelectDB:
+			if _, _, err := d.readLength(); err != nil {
+				return nil, fmt.Errorf("failed to read db number: %w", err)
+			}
+		case opResizeDB:
+			for range 2 {
+				if _, _, err := d.readLength(); err != nil {
+					return nil, fmt.Errorf("failed to read resize db hint: %w", err)
+				}
+			}
+		case opExpireTime:
+			buf := make([]byte, 4)
+			if _, err := io.ReadFull(d.r, buf); err != nil {
+				return nil, fmt.Errorf("failed to read expire time: %w", err)
+			}
+			t := time.Unix(int64(binary.LittleEndian.Uint32(buf)), 0)
+			expireAt = &t
+		case opExpireTimeMs:
+			buf := make([]byte, 8)
+			if _, err := io.ReadFull(d.r, buf); err != nil {
+				return nil, fmt.Errorf("failed to read expire time: %w", err)
+			}
+			t := time.UnixMilli(int64(binary.LittleEndian.Uint64(buf)))
+			expireAt = &t
+		case opIdle:
+			if _, _, err := d.readLength(); err != nil {
+				return nil, fmt.Errorf("failed to read idle time: %w", err)
+			}
+		case opFreq:
+			if _, err := d.r.ReadByte(); err != nil {
+				return nil, fmt.Errorf("failed to read access frequency: %w", err)
+			}
+		case opFunction2, opModuleAux:
+			return nil, fmt.Errorf("unsupported rdb opcode: %#x", opcode)
+		case typeString:
+			key, err := d.readString()
+			if err != nil {
+				return nil, fmt.Errorf("failed to read key: %w", err)
+			}
+			value, err := d.readString()
+			if err != nil {
+				return nil, fmt.Errorf("failed to read value of key %s: %w", key, err)
+			}
+			snapshot.Entries = append(snapshot.Entries, Entry{
+				Key: key,
+				Record: &storage.KVRecord{
+					Value:    value,
+					ExpireAt: expireAt,
+				},
+			})
+			expireAt = nil
+		default:
+			return nil, fmt.Errorf("unsupported rdb value type: %#x", opcode)
+		}
+	}
+}
+
+// readLength reads a length-encoded integer. If the value is a special string
+// encoding instead of a length, encoded is true and the encoding is returned.
+func (d *decoder) readLength() (uint64, bool, error) {
+	first, err := d.r.ReadByte()
+	if err != nil {
+		return 0, false, err
+	}
+	switch first >> 6 {
+	case 0b00:
+		return uint64(first & 0x3F), false, nil
+	case 0b01:
+		next, err := d.r.ReadByte()
+		if err != nil {
+			return 0, false, err
+		}
+		return uint64(first&0x3F)<<8 | uint64(next), false, nil
+	case 0b10:
+		switch first {
+		case 0x80:
+			buf := make([]byte, 4)
+			if _, err := io.ReadFull(d.r, buf); err != nil {
+				return 0, false, err
+			}
+			return uint64(binary.BigEndian.Uint32(buf)), false, nil
+		case 0x81:
+			buf := make([]byte, 8)
+			if _, err := io.ReadFull(d.r, buf); err != nil {
+				return 0, false, err
+			}
+			return binary.BigEndian.Uint64(buf), false, nil
+		}
+		return 0, false, fmt.Errorf("invalid length encoding: %#x", first)
+	default:
+		return uint64(first & 0x3F), true, nil
+	}
+}
+
+func (d *decoder) readString() (string, error) {
+	length, encoded, err := d.readLength()
+	if err != nil {
+		return "", err
+	}
+	if !encoded {
+		buf, err := d.readBytes(length)
+		if err != nil {
+			return "", err
+		}
+		return string(buf), nil
+	}
+
+	switch length {
+	case encInt8:
+		b, err := d.r.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		return strconv.Itoa(int(int8(b))), nil
+	case encInt16:
+		buf := make([]byte, 2)
+		if _, err := io.ReadFull(d.r, buf); err != nil {
+			return "", err
+		}
+		return strconv.Itoa(int(int16(binary.LittleEndian.Uint16(buf)))), nil
+	case encInt32:
+		buf := make([]byte, 4)
+		if _, err := io.ReadFull(d.r, buf); err != nil {
+			return "", err
+		}
+		return strconv.Itoa(int(int32(binary.LittleEndian.Uint32(buf)))), nil
+	case encLZF:
+		return d.readLZFString()
+	}
+	return "", fmt.Errorf("unsupported string encoding: %d", length)
+}
+
+func (d *decoder) readLZFString() (string, error) {
+	compressedLen, _, err := d.readLength()
+	if err != nil {
+		return "", err
+	}
+	length, _, err := d.readLength()
+	if err != nil {
+		return "", err
+	}
+	if length > compressedLen*lzfMaxExpansion {
+		return "", fmt.Errorf("%w: %d bytes can't inflate to %d", errLZFCorrupted, compressedLen, length)
+	}
+	compressed, err := d.readBytes(compressedLen)
+	if err != nil {
+		return "", err
+	}
+	out, err := lzfDecompress(compressed, int(length))
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+var errLZFCorrupted = errors.New("corrupted lzf compressed string")
+
+// errLengthOutOfRange is returned for a length larger than the rest of the
+// payload, which a malformed or truncated file would otherwise allocate.
+var errLengthOutOfRange = errors.New("length out of range")
+
+// lzfMaxExpansion bounds how much liblzf inflates its input: a back reference
+// of at most 3 bytes expands to at most 264 bytes.
+const lzfMaxExpansion = 88
+
+// readBytes reads the next n bytes, checking n against what is left of the
+// payload before allocating.
+func (d *decoder) readBytes(n uint64) ([]byte, error) {
+	if n > uint64(d.r.Len()) {
+		return nil, fmt.Errorf("%w: %d bytes, %d left", errLengthOutOfRange, n, d.r.Len())
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(d.r, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// lzfDecompress inflates data compressed with liblzf, which Redis uses
+// for long strings.
+func lzfDecompress(in []byte, length int) ([]byte, error) {
+	out := make([]byte, 0, length)
+	for i := 0; i < len(in); {
+		ctrl := int(in[i])
+		i++
+		if ctrl < 32 {
+			// Literal run of ctrl+1 bytes.
+			end := i + ctrl + 1
+			if end > len(in) || len(out)+ctrl+1 > length {
+				return nil, errLZFCorrupted
+			}
+			out = append(out, in[i:end]...)
+			i = end
+			continue
+		}
+
+		// Back reference.
+		refLen := ctrl >> 5
+		if refLen == 7 {
+			if i >= len(in) {
+				return nil, errLZFCorrupted
+			}
+			refLen += int(in[i])
+			i++
+		}
+		if i >= len(in) {
+			return nil, errLZFCorrupted
+		}
+		ref := len(out) - (ctrl&0x1F)<<8 - int(in[i]) - 1
+		i++
+		if ref < 0 {
+			return nil, errLZFCorrupted
+		}
+		if len(out)+refLen+2 > length {
+			return nil, errLZFCorrupted
+		}
+		for j := 0; j < refLen+2; j++ {
+			out = append(out, out[ref+j])
+		}
+	}
+	if len(out) != length {
+		return nil, errLZFCorrupted
+	}
+	return out, nil
+}
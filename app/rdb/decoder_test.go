@@ -0,0 +1,128 @@
+package rdb
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/jorzel/myredis/app/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// withChecksum appends the CRC64 footer to an RDB body ending with opEOF.
+func withChecksum(body []byte) []byte {
+	footer := make([]byte, 8)
+	binary.LittleEndian.PutUint64(footer, crc64(0, body))
+	return append(body, footer...)
+}
+
+func TestDecodeEmptyRDB(t *testing.T) {
+	data, err := hex.DecodeString("524544495330303131fa0972656469732d76657205372e322e30fa0a72656469732d62697473c040fa056374696d65c26d08bc65fa08757365642d6d656dc2b0c41000fa08616f662d62617365c000fff06e3bfec0ff5aa2")
+	require.NoError(t, err)
+
+	snapshot, err := Decode(data)
+
+	require.NoError(t, err, "Expected no error decoding empty RDB")
+	assert.Equal(t, 11, snapshot.Version)
+	assert.Equal(t, "7.2.0", snapshot.Aux["redis-ver"])
+	assert.Equal(t, "64", snapshot.Aux["redis-bits"])
+	assert.Empty(t, snapshot.Entries)
+}
+
+func TestDecodeEntries(t *testing.T) {
+	body := []byte("REDIS0011")
+	body = append(body, opSelectDB, 0x00, opResizeDB, 0x03, 0x01)
+	// Plain string
+	body = append(body, typeString, 0x03, 'f', 'o', 'o', 0x03, 'b', 'a', 'r')
+	// Integer encoded value with a millisecond expiry
+	body = append(body, opExpireTimeMs)
+	body = binary.LittleEndian.AppendUint64(body, 1956528000000)
+	body = append(body, typeString, 0x03, 'n', 'u', 'm', 0xC1, 0x39, 0x30)
+	// LZF compressed value
+	body = append(body, typeString, 0x03, 'l', 'z', 'f', 0xC3, 0x05, 0x0A, 0x00, 'a', 0xE0, 0x00, 0x00)
+	body = append(body, opEOF)
+
+	snapshot, err := Decode(withChecksum(body))
+
+	require.NoError(t, err, "Expected no error decoding RDB")
+	require.Len(t, snapshot.Entries, 3)
+	assert.Equal(t, "foo", snapshot.Entries[0].Key)
+	assert.Equal(t, "bar", snapshot.Entries[0].Record.Value)
+	assert.Nil(t, snapshot.Entries[0].Record.ExpireAt)
+	assert.Equal(t, "num", snapshot.Entries[1].Key)
+	assert.Equal(t, "12345", snapshot.Entries[1].Record.Value)
+	require.NotNil(t, snapshot.Entries[1].Record.ExpireAt)
+	assert.Equal(t, int64(1956528000000), snapshot.Entries[1].Record.ExpireAt.UnixMilli())
+	assert.Equal(t, "lzf", snapshot.Entries[2].Key)
+	assert.Equal(t, "aaaaaaaaaa", snapshot.Entries[2].Record.Value)
+}
+
+func TestDecodeMalformedLengths(t *testing.T) {
+	tests := []struct {
+		name  string
+		entry []byte
+	}{
+		{name: "truncated string", entry: []byte{typeString, 0x03, 'f', 'o', 'o', 0x05, 'b', 'a'}},
+		{name: "32 bit length", entry: []byte{typeString, 0x80, 0x7F, 0xFF, 0xFF, 0xFF}},
+		{name: "64 bit length", entry: []byte{typeString, 0x81, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}},
+		{name: "lzf compressed length", entry: []byte{typeString, 0x01, 'k', 0xC3, 0x81, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xF0, 0x05}},
+		{name: "lzf inflated length", entry: []byte{typeString, 0x01, 'k', 0xC3, 0x02, 0x81, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xF0, 0x00, 'a'}},
+		{name: "lzf overlong back reference", entry: []byte{typeString, 0x01, 'k', 0xC3, 0x04, 0x03, 0x00, 'a', 0xE0, 0x00}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := append([]byte("REDIS0011"), tt.entry...)
+
+			assert.NotPanics(t, func() {
+				_, err := Decode(withChecksum(append(body, opEOF)))
+				assert.Error(t, err)
+			})
+		})
+	}
+}
+
+func TestDecodeChecksumMismatch(t *testing.T) {
+	data := withChecksum([]byte("REDIS0011\xff"))
+	data[len(data)-1] ^= 0xFF
+
+	_, err := Decode(data)
+
+	assert.ErrorContains(t, err, "checksum mismatch")
+}
+
+func TestLoadSkipsExpiredKeys(t *testing.T) {
+	body := []byte("REDIS0011")
+	body = append(body, opExpireTime)
+	body = binary.LittleEndian.AppendUint32(body, uint32(time.Now().Add(-time.Hour).Unix()))
+	body = append(body, typeString, 0x01, 'a', 0x01, '1')
+	body = append(body, typeString, 0x01, 'b', 0x01, '2')
+	body = append(body, opEOF)
+	s := storage.NewStorage()
+	s.Set("stale", &storage.KVRecord{Value: "x"})
+
+	err := Load(withChecksum(body), s)
+
+	require.NoError(t, err, "Expected no error loading RDB")
+	record, _ := s.Get("a")
+	assert.Nil(t, record, "Expected expired key to be skipped")
+	record, _ = s.Get("stale")
+	assert.Nil(t, record, "Expected previous content to be replaced")
+	record, _ = s.Get("b")
+	require.NotNil(t, record)
+	assert.Equal(t, "2", record.Value)
+}
+
+func TestCRC64(t *testing.T) {
+	assert.Equal(t, uint64(0xe9c6d914c4b8d9ca), crc64(0, []byte("123456789")))
+}
+
+func FuzzDecode(f *testing.F) {
+	f.Add(withChecksum([]byte("REDIS0011\xff")))
+	f.Add(withChecksum([]byte("REDIS0011\x00\x03foo\xc3\x05\x0a\x00a\xe0\x00\x00\xff")))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		// Malformed payloads have to fail with an error, never a panic.
+		Decode(data)
+	})
+}
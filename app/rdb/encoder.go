@@ -0,0 +1,123 @@
+package rdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/jorzel/myredis/app/config"
+	"github.com/jorzel/myredis/app/storage"
+)
+
+// Version is the RDB format version written by the encoder.
+const Version = 11
+
+// Dump serializes the current content of s into an RDB payload with
+// millisecond expiry opcodes and a CRC64 checksum footer.
+// Keys that are already expired are skipped.
+func Dump(s storage.Storage) []byte {
+	return Encode(Collect(s))
+}
+
+// Encode serializes entries into an RDB payload.
+func Encode(entries []Entry) []byte {
+	e := &encoder{}
+	fmt.Fprintf(&e.buf, "%s%04d", magic, Version)
+
+	e.writeAux("redis-ver", config.RedisVersion)
+	e.writeAux("redis-bits", strconv.Itoa(strconv.IntSize))
+	e.writeAux("ctime", strconv.FormatInt(time.Now().Unix(), 10))
+	e.writeAux("aof-base", "0")
+
+	expires := 0
+	for _, entry := range entries {
+		if entry.Record.ExpireAt != nil {
+			expires++
+		}
+	}
+	e.buf.WriteByte(opSelectDB)
+	e.writeLength(0)
+	e.buf.WriteByte(opResizeDB)
+	e.writeLength(uint64(len(entries)))
+	e.writeLength(uint64(expires))
+
+	for _, entry := range entries {
+		if entry.Record.ExpireAt != nil {
+			e.buf.WriteByte(opExpireTimeMs)
+			e.buf.Write(binary.LittleEndian.AppendUint64(nil, uint64(entry.Record.ExpireAt.UnixMilli())))
+		}
+		e.buf.WriteByte(typeString)
+		e.writeString(entry.Key)
+		e.writeString(entry.Record.Value)
+	}
+
+	e.buf.WriteByte(opEOF)
+	checksum := crc64(0, e.buf.Bytes())
+	e.buf.Write(binary.LittleEndian.AppendUint64(nil, checksum))
+	return e.buf.Bytes()
+}
+
+// Collect returns the keys of s that are not expired. Stored records are never
+// modified in place, so the entries stay a consistent view of s as long as no
+// write runs while they are collected.
+func Collect(s storage.Storage) []Entry {
+	now := time.Now()
+	var entries []Entry
+	s.Range(func(key string, record *storage.KVRecord) bool {
+		if record.ExpireAt != nil && record.ExpireAt.Before(now) {
+			return true
+		}
+		entries = append(entries, Entry{Key: key, Record: record})
+		return true
+	})
+	return entries
+}
+
+type encoder struct {
+	buf bytes.Buffer
+}
+
+func (e *encoder) writeAux(key, value string) {
+	e.buf.WriteByte(opAux)
+	e.writeString(key)
+	e.writeString(value)
+}
+
+func (e *encoder) writeLength(length uint64) {
+	switch {
+	case length < 1<<6:
+		e.buf.WriteByte(byte(length))
+	case length < 1<<14:
+		e.buf.WriteByte(byte(length>>8) | 0x40)
+		e.buf.WriteByte(byte(length))
+	case length <= 0xFFFFFFFF:
+		e.buf.WriteByte(0x80)
+		e.buf.Write(binary.BigEndian.AppendUint32(nil, uint32(length)))
+	default:
+		e.buf.WriteByte(0x81)
+		e.buf.Write(binary.BigEndian.AppendUint64(nil, length))
+	}
+}
+
+// writeString writes s, using the compact integer encoding for strings that
+// are the canonical representation of a 32 bit integer, as Redis does.
+func (e *encoder) writeString(s string) {
+	if n, err := strconv.ParseInt(s, 10, 32); err == nil && strconv.FormatInt(n, 10) == s {
+		switch {
+		case n >= -1<<7 && n < 1<<7:
+			e.buf.WriteByte(0xC0 | encInt8)
+			e.buf.WriteByte(byte(int8(n)))
+		case n >= -1<<15 && n < 1<<15:
+			e.buf.WriteByte(0xC0 | encInt16)
+			e.buf.Write(binary.LittleEndian.AppendUint16(nil, uint16(int16(n))))
+		default:
+			e.buf.WriteByte(0xC0 | encInt32)
+			e.buf.Write(binary.LittleEndian.AppendUint32(nil, uint32(int32(n))))
+		}
+		return
+	}
+	e.writeLength(uint64(len(s)))
+	e.buf.WriteString(s)
+}
@@ -0,0 +1,46 @@
+package rdb
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jorzel/myredis/app/config"
+	"github.com/jorzel/myredis/app/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDumpRoundTrip(t *testing.T) {
+	expireAt := time.UnixMilli(time.Now().Add(time.Hour).UnixMilli())
+	expired := time.Now().Add(-time.Hour)
+	s := storage.NewStorage()
+	s.Set("plain", &storage.KVRecord{Value: "value"})
+	s.Set("small", &storage.KVRecord{Value: "-12"})
+	s.Set("medium", &storage.KVRecord{Value: "30000"})
+	s.Set("large", &storage.KVRecord{Value: "2000000000"})
+	s.Set("padded", &storage.KVRecord{Value: "007"})
+	s.Set("long", &storage.KVRecord{Value: strings.Repeat("x", 20000)})
+	s.Set("expiring", &storage.KVRecord{Value: "soon", ExpireAt: &expireAt})
+	s.Set("expired", &storage.KVRecord{Value: "gone", ExpireAt: &expired})
+
+	data := Dump(s)
+
+	assert.Equal(t, "REDIS0011", string(data[:9]))
+	snapshot, err := Decode(data)
+	require.NoError(t, err, "Expected dumped RDB to decode")
+	assert.Equal(t, config.RedisVersion, snapshot.Aux["redis-ver"])
+	decoded := map[string]*storage.KVRecord{}
+	for _, entry := range snapshot.Entries {
+		decoded[entry.Key] = entry.Record
+	}
+	require.Len(t, decoded, 7, "Expected every key except the expired one")
+	for _, key := range []string{"plain", "small", "medium", "large", "padded", "long"} {
+		original, _ := s.Get(key)
+		require.Contains(t, decoded, key)
+		assert.Equal(t, original.Value, decoded[key].Value, "Value mismatch for key %s", key)
+		assert.Nil(t, decoded[key].ExpireAt, "Unexpected expiry for key %s", key)
+	}
+	require.NotNil(t, decoded["expiring"].ExpireAt)
+	assert.Equal(t, expireAt, *decoded["expiring"].ExpireAt)
+}
@@ -0,0 +1,72 @@
+package replication
+
+// DefaultBacklogSize is the number of bytes of recent replication traffic
+// kept for partial resynchronization.
+const DefaultBacklogSize = 1024 * 1024
+
+// Backlog is a fixed-size circular buffer with the most recent bytes of the
+// replication stream.
+type Backlog struct {
+	buf []byte
+	// end is the replication offset right after the last written byte.
+	end int64
+	// length is the number of valid bytes in buf.
+	length int
+}
+
+func NewBacklog(size int) *Backlog {
+	return &Backlog{
+		buf: make([]byte, size),
+	}
+}
+
+// Reset drops the content of the backlog and makes it continue from offset.
+func (b *Backlog) Reset(offset int64) {
+	b.end = offset
+	b.length = 0
+}
+
+// Write appends p to the backlog, overwriting the oldest bytes once it is full.
+func (b *Backlog) Write(p []byte) {
+	size := len(b.buf)
+	b.end += int64(len(p))
+	if len(p) > size {
+		p = p[len(p)-size:]
+	}
+	pos := int((b.end - int64(len(p))) % int64(size))
+	n := copy(b.buf[pos:], p)
+	copy(b.buf, p[n:])
+	b.length = min(b.length+len(p), size)
+}
+
+// Start returns the replication offset of the oldest byte in the backlog.
+func (b *Backlog) Start() int64 {
+	return b.end - int64(b.length)
+}
+
+// Size returns the capacity of the backlog in bytes.
+func (b *Backlog) Size() int {
+	return len(b.buf)
+}
+
+// End returns the replication offset right after the newest byte in the backlog.
+func (b *Backlog) End() int64 {
+	return b.end
+}
+
+// ReadFrom returns the bytes from offset up to the end of the backlog.
+// It returns false if offset is not covered by the backlog.
+func (b *Backlog) ReadFrom(offset int64) ([]byte, bool) {
+	if offset < b.Start() || offset > b.end {
+		return nil, false
+	}
+	size := int64(len(b.buf))
+	out := make([]byte, 0, b.end-offset)
+	for offset < b.end {
+		pos := offset % size
+		chunk := min(b.end-offset, size-pos)
+		out = append(out, b.buf[pos:pos+chunk]...)
+		offset += chunk
+	}
+	return out, true
+}
@@ -0,0 +1,79 @@
+package replication
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBacklog(t *testing.T) {
+	tests := []struct {
+		name          string
+		size          int
+		writes        []string
+		readFrom      int64
+		expectedOK    bool
+		expectedBytes string
+	}{
+		{
+			name:          "Read everything",
+			size:          16,
+			writes:        []string{"abc", "def"},
+			readFrom:      0,
+			expectedOK:    true,
+			expectedBytes: "abcdef",
+		},
+		{
+			name:          "Read from the end",
+			size:          16,
+			writes:        []string{"abc"},
+			readFrom:      3,
+			expectedOK:    true,
+			expectedBytes: "",
+		},
+		{
+			name:          "Read across wrap around",
+			size:          4,
+			writes:        []string{"abc", "def"},
+			readFrom:      2,
+			expectedOK:    true,
+			expectedBytes: "cdef",
+		},
+		{
+			name:       "Offset already overwritten",
+			size:       4,
+			writes:     []string{"abc", "def"},
+			readFrom:   1,
+			expectedOK: false,
+		},
+		{
+			name:          "Write larger than backlog",
+			size:          4,
+			writes:        []string{"abcdefgh"},
+			readFrom:      4,
+			expectedOK:    true,
+			expectedBytes: "efgh",
+		},
+		{
+			name:       "Offset in the future",
+			size:       4,
+			writes:     []string{"ab"},
+			readFrom:   3,
+			expectedOK: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backlog := NewBacklog(tt.size)
+			for _, w := range tt.writes {
+				backlog.Write([]byte(w))
+			}
+			data, ok := backlog.ReadFrom(tt.readFrom)
+			require.Equal(t, tt.expectedOK, ok, "Unexpected backlog coverage")
+			if ok {
+				assert.Equal(t, tt.expectedBytes, string(data), "Unexpected backlog content")
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package replication
+
+import (
+	"sync"
+
+	"github.com/jorzel/myredis/app/config"
+)
+
+// Replica link states, named as reported by the ROLE command.
+const (
+	LinkConnect    = "connect"
+	LinkConnecting = "connecting"
+	LinkSync       = "sync"
+	LinkConnected  = "connected"
+)
+
+// Link holds the replica side state of the connection to a master. The
+// replication ID and offset the replica follows are kept by its Master,
+// which serves them to sub-replicas.
+type Link struct {
+	mu     sync.Mutex
+	master *config.Node
+	state  string
+}
+
+// NewLink creates a link to master. A nil master means the server is a master itself.
+func NewLink(master *config.Node) *Link {
+	return &Link{
+		master: master,
+		state:  LinkConnect,
+	}
+}
+
+// Master returns the address of the followed master, nil if the server is a master.
+func (l *Link) Master() *config.Node {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.master
+}
+
+// SetMaster starts following master, or stops following any if master is nil.
+func (l *Link) SetMaster(master *config.Node) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.master = master
+	l.state = LinkConnect
+}
+
+// State returns the current state of the connection to the master.
+func (l *Link) State() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.state
+}
+
+// SetState records the current state of the connection to the master.
+func (l *Link) SetState(state string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.state = state
+}
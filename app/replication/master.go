@@ -0,0 +1,361 @@
+package replication
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jorzel/myredis/app/protocol"
+	"github.com/rs/zerolog"
+)
+
+// Replica is a connection that completed PSYNC and receives the stream of
+// write commands from the master.
+type Replica struct {
+	conn net.Conn
+	// listeningPort is the port the replica accepts clients on, as announced
+	// with REPLCONF listening-port.
+	listeningPort int
+	// announcedIP is the address announced with REPLCONF ip-address, if any.
+	announcedIP string
+	ackOffset   atomic.Int64
+	// ackTime is the unix time in milliseconds of the last acknowledgement.
+	ackTime atomic.Int64
+}
+
+// Addr returns the remote address of the replica connection.
+func (r *Replica) Addr() string {
+	addr := r.conn.RemoteAddr()
+	if addr == nil {
+		return ""
+	}
+	return addr.String()
+}
+
+// IP returns the IP address the replica announced, or else the one it
+// connected from.
+func (r *Replica) IP() string {
+	if r.announcedIP != "" {
+		return r.announcedIP
+	}
+	host, _, err := net.SplitHostPort(r.Addr())
+	if err != nil {
+		return r.Addr()
+	}
+	return host
+}
+
+// ListeningPort returns the port the replica accepts clients on.
+func (r *Replica) ListeningPort() int {
+	return r.listeningPort
+}
+
+// AckOffset returns the last replication offset acknowledged by the replica.
+func (r *Replica) AckOffset() int64 {
+	return r.ackOffset.Load()
+}
+
+// Lag returns the time since the replica last acknowledged an offset.
+func (r *Replica) Lag() time.Duration {
+	return time.Since(time.UnixMilli(r.ackTime.Load()))
+}
+
+// announcement is what a connection announced with REPLCONF before PSYNC.
+type announcement struct {
+	listeningPort int
+	ip            string
+}
+
+// Master keeps track of connected replicas and forwards write commands to them.
+// On a replica it serves sub-replicas with the stream received from its master.
+type Master struct {
+	mu sync.Mutex
+	// feedMu keeps replication traffic in the same order in the backlog and
+	// on every replica connection, which are written without holding mu.
+	feedMu sync.Mutex
+	replID string
+	// replID2 is the previous replication ID, still accepted for partial
+	// resynchronization up to replID2Offset after a failover.
+	replID2       string
+	replID2Offset int64
+	replicas      []*Replica
+	// announced keeps what connections that have not completed PSYNC yet
+	// announced with REPLCONF.
+	announced map[net.Conn]announcement
+	// backlog holds recent replication traffic, its end is the master offset.
+	backlog *Backlog
+	// acked is closed and replaced whenever a replica acknowledges an offset.
+	acked chan struct{}
+}
+
+func NewMaster() *Master {
+	return &Master{
+		replID:    NewReplID(),
+		announced: map[net.Conn]announcement{},
+		backlog:   NewBacklog(DefaultBacklogSize),
+		acked:     make(chan struct{}),
+	}
+}
+
+// NewReplID generates a random 40 characters long replication ID.
+func NewReplID() string {
+	id := make([]byte, 20)
+	if _, err := rand.Read(id); err != nil {
+		panic(fmt.Sprintf("failed to generate replication ID: %v", err))
+	}
+	return hex.EncodeToString(id)
+}
+
+// ReplID returns the replication ID of the master's data set history.
+func (m *Master) ReplID() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.replID
+}
+
+// ReplID2 returns the previous replication ID and the offset up to which
+// it is still accepted, empty if there was no failover.
+func (m *Master) ReplID2() (string, int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.replID2, m.replID2Offset
+}
+
+// CanContinue reports whether a replica that processed the stream of replID
+// up to offset can be partially resynchronized from the backlog.
+func (m *Master) CanContinue(replID string, offset int64) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	switch {
+	case replID == m.replID:
+	case replID == m.replID2 && offset <= m.replID2Offset:
+	default:
+		return false
+	}
+	return offset >= m.backlog.Start() && offset <= m.backlog.End()
+}
+
+// ShiftReplID starts a new history with replID, keeping the current one
+// acceptable for partial resynchronization up to the current offset.
+// It is used when a replica is promoted, or its master was.
+func (m *Master) ShiftReplID(replID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.replID2 = m.replID
+	m.replID2Offset = m.backlog.End()
+	m.replID = replID
+}
+
+// Reset makes the master follow the history of replID from offset with an
+// empty backlog. Connected replicas are disconnected, as their data set no
+// longer matches. It is used when a replica fully resynchronizes.
+func (m *Master) Reset(replID string, offset int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.replID = replID
+	m.replID2 = ""
+	m.replID2Offset = 0
+	m.backlog.Reset(offset)
+	m.disconnectLocked()
+}
+
+// DisconnectReplicas closes the connections of all replicas.
+func (m *Master) DisconnectReplicas() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.disconnectLocked()
+}
+
+// SetListeningPort records the port announced by conn, before it becomes a replica.
+func (m *Master) SetListeningPort(conn net.Conn, port int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	a := m.announced[conn]
+	a.listeningPort = port
+	m.announced[conn] = a
+}
+
+// SetAnnouncedIP records the address announced by conn, before it becomes a
+// replica. It is reported instead of the one the replica connected from.
+func (m *Master) SetAnnouncedIP(conn net.Conn, ip string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	a := m.announced[conn]
+	a.ip = ip
+	m.announced[conn] = a
+}
+
+// BacklogInfo returns the replication offset of the oldest byte in the
+// backlog, the number of bytes it holds and its capacity.
+func (m *Master) BacklogInfo() (start int64, length int, size int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.backlog.Start(), int(m.backlog.End() - m.backlog.Start()), m.backlog.Size()
+}
+
+// AddReplica registers conn as a replica, so it receives every propagated command.
+// The replica is assumed to have processed the stream up to offset, anything
+// after it is sent from the backlog first.
+func (m *Master) AddReplica(conn net.Conn, offset int64) (*Replica, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	missing, ok := m.backlog.ReadFrom(offset)
+	if !ok {
+		return nil, fmt.Errorf("offset %d is not available in the replication backlog", offset)
+	}
+	if len(missing) > 0 {
+		if _, err := conn.Write(missing); err != nil {
+			return nil, fmt.Errorf("failed to send replication backlog: %w", err)
+		}
+	}
+	replica := &Replica{
+		conn:          conn,
+		listeningPort: m.announced[conn].listeningPort,
+		announcedIP:   m.announced[conn].ip,
+	}
+	replica.ackTime.Store(time.Now().UnixMilli())
+	delete(m.announced, conn)
+	m.replicas = append(m.replicas, replica)
+	return replica, nil
+}
+
+// RemoveReplica unregisters the replica bound to conn, or forgets what it
+// announced if it never completed PSYNC.
+func (m *Master) RemoveReplica(conn net.Conn) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.announced, conn)
+	m.removeLocked(conn)
+}
+
+// Replicas returns a snapshot of currently connected replicas.
+func (m *Master) Replicas() []*Replica {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	replicas := make([]*Replica, len(m.replicas))
+	copy(replicas, m.replicas)
+	return replicas
+}
+
+// Offset returns the current master replication offset.
+func (m *Master) Offset() int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.backlog.End()
+}
+
+// Ack records offset as acknowledged by the replica bound to conn.
+// It returns false if conn is not a registered replica.
+func (m *Master) Ack(conn net.Conn, offset int64) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, replica := range m.replicas {
+		if replica.conn == conn {
+			replica.ackOffset.Store(offset)
+			replica.ackTime.Store(time.Now().UnixMilli())
+			close(m.acked)
+			m.acked = make(chan struct{})
+			return true
+		}
+	}
+	return false
+}
+
+// WaitForAcks blocks until at least numReplicas replicas acknowledged the
+// current master offset, the timeout passes or ctx is done. A zero timeout
+// waits without a limit. It returns the number of replicas that acknowledged.
+func (m *Master) WaitForAcks(ctx context.Context, numReplicas int, timeout time.Duration) int {
+	target := m.Offset()
+	acked, notify := m.countAcked(target)
+	if acked >= numReplicas {
+		return acked
+	}
+
+	m.Propagate(ctx, protocol.NewCommand(protocol.REPLCONF, []string{"GETACK", "*"}))
+
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+	for acked < numReplicas {
+		select {
+		case <-notify:
+			acked, notify = m.countAcked(target)
+		case <-deadline:
+			acked, _ = m.countAcked(target)
+			return acked
+		case <-ctx.Done():
+			return acked
+		}
+	}
+	return acked
+}
+
+// countAcked returns the number of replicas that acknowledged offset, along
+// with a channel closed on the next acknowledgement.
+func (m *Master) countAcked(offset int64) (int, <-chan struct{}) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	count := 0
+	for _, replica := range m.replicas {
+		if replica.AckOffset() >= offset {
+			count++
+		}
+	}
+	return count, m.acked
+}
+
+// Propagate sends command to every connected replica.
+func (m *Master) Propagate(ctx context.Context, command protocol.Command) {
+	m.Feed(ctx, command.Serialize())
+}
+
+// Feed appends raw replication traffic to the backlog and sends it to every
+// connected replica. Replicas whose connection fails are dropped.
+func (m *Master) Feed(ctx context.Context, payload []byte) {
+	logger := zerolog.Ctx(ctx)
+
+	m.feedMu.Lock()
+	defer m.feedMu.Unlock()
+	m.mu.Lock()
+	m.backlog.Write(payload)
+	replicas := slices.Clone(m.replicas)
+	m.mu.Unlock()
+
+	// A slow replica must not hold up acknowledgements and INFO, so the
+	// connections are written outside mu.
+	for _, replica := range replicas {
+		if _, err := replica.conn.Write(payload); err != nil {
+			logger.Err(err).
+				Str("replica", replica.Addr()).
+				Msg("Failed to propagate command to replica, dropping it")
+			m.mu.Lock()
+			m.removeLocked(replica.conn)
+			m.mu.Unlock()
+		}
+	}
+}
+
+func (m *Master) disconnectLocked() {
+	for _, replica := range m.replicas {
+		replica.conn.Close()
+	}
+	m.replicas = nil
+}
+
+func (m *Master) removeLocked(conn net.Conn) {
+	for i, replica := range m.replicas {
+		if replica.conn == conn {
+			m.replicas = append(m.replicas[:i], m.replicas[i+1:]...)
+			return
+		}
+	}
+}
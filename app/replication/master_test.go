@@ -0,0 +1,61 @@
+package replication
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMasterFeedDoesNotBlockOnSlowReplica(t *testing.T) {
+	m := NewMaster()
+	// Writes to a pipe block until the other end reads them.
+	conn, peer := net.Pipe()
+	defer conn.Close()
+	defer peer.Close()
+	_, err := m.AddReplica(conn, 0)
+	require.NoError(t, err)
+
+	fed := make(chan struct{})
+	go func() {
+		defer close(fed)
+		m.Feed(context.Background(), []byte("*1\r\n$4\r\nPING\r\n"))
+	}()
+
+	require.Eventually(t, func() bool {
+		return m.Offset() == 14
+	}, time.Second, 10*time.Millisecond)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		assert.True(t, m.Ack(conn, 14))
+		assert.Len(t, m.Replicas(), 1)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("master blocked while a replica was not reading")
+	}
+
+	payload := make([]byte, 14)
+	_, err = io.ReadFull(peer, payload)
+	require.NoError(t, err)
+	assert.Equal(t, "*1\r\n$4\r\nPING\r\n", string(payload))
+	<-fed
+}
+
+func TestMasterFeedDropsFailedReplicas(t *testing.T) {
+	m := NewMaster()
+	conn, peer := net.Pipe()
+	_, err := m.AddReplica(conn, 0)
+	require.NoError(t, err)
+	peer.Close()
+
+	m.Feed(context.Background(), []byte("*1\r\n$4\r\nPING\r\n"))
+	assert.Empty(t, m.Replicas())
+	assert.Equal(t, int64(14), m.Offset())
+}
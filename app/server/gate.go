@@ -0,0 +1,80 @@
+package server
+
+import "sync"
+
+// gate admits commands to the handler. While shutdown is being prepared,
+// writes are held back, so none of them lands after the final snapshot or
+// goes missing on replicas. Once the gate is closed nothing is admitted.
+type gate struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+	// running counts admitted commands, writes the admitted write commands.
+	running int
+	writes  int
+	paused  bool
+	closed  bool
+}
+
+func newGate() *gate {
+	g := &gate{}
+	g.cond = sync.NewCond(&g.mu)
+	return g
+}
+
+// enter admits a command, waiting while writes are paused if it is a write.
+// It returns false once the gate is closed.
+func (g *gate) enter(write bool) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for write && g.paused && !g.closed {
+		g.cond.Wait()
+	}
+	if g.closed {
+		return false
+	}
+	g.running++
+	if write {
+		g.writes++
+	}
+	return true
+}
+
+// leave marks an admitted command as done.
+func (g *gate) leave(write bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.running--
+	if write {
+		g.writes--
+	}
+	g.cond.Broadcast()
+}
+
+// pauseWrites holds back new writes and waits for the running ones.
+func (g *gate) pauseWrites() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.paused = true
+	for g.writes > 0 {
+		g.cond.Wait()
+	}
+}
+
+// resumeWrites admits writes again.
+func (g *gate) resumeWrites() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.paused = false
+	g.cond.Broadcast()
+}
+
+// close stops admitting commands and waits for the running ones.
+func (g *gate) close() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.closed = true
+	g.cond.Broadcast()
+	for g.running > 0 {
+		g.cond.Wait()
+	}
+}
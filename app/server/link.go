@@ -0,0 +1,369 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jorzel/myredis/app/client"
+	"github.com/jorzel/myredis/app/commands"
+	"github.com/jorzel/myredis/app/config"
+	"github.com/jorzel/myredis/app/persistence"
+	"github.com/jorzel/myredis/app/protocol"
+	"github.com/jorzel/myredis/app/rdb"
+	"github.com/jorzel/myredis/app/replication"
+	"github.com/jorzel/myredis/app/storage"
+	"github.com/rs/zerolog"
+)
+
+const (
+	minReconnectBackoff = 100 * time.Millisecond
+	maxReconnectBackoff = 10 * time.Second
+	// ackInterval is how often the replica reports its offset to the master.
+	ackInterval = time.Second
+)
+
+// masterLink replicates the data set of a master server. The received stream
+// is applied to the local storage and fed to the local replication master,
+// which serves it to sub-replicas.
+type masterLink struct {
+	address        string
+	state          *replication.Link
+	replication    *replication.Master
+	storage        storage.Storage
+	aof            *persistence.AOF
+	commandHandler commands.CommandHandler
+	config         *config.Config
+
+	mu     sync.Mutex
+	conn   net.Conn
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func newMasterLink(
+	master *config.Node,
+	state *replication.Link,
+	repl *replication.Master,
+	store storage.Storage,
+	aof *persistence.AOF,
+	handler commands.CommandHandler,
+	cfg *config.Config,
+) *masterLink {
+	return &masterLink{
+		address:        fmt.Sprintf("%s:%d", master.Host, master.Port),
+		state:          state,
+		replication:    repl,
+		storage:        store,
+		aof:            aof,
+		commandHandler: handler,
+		config:         cfg,
+		done:           make(chan struct{}),
+	}
+}
+
+// start keeps the link connected in the background until stop is called.
+func (ml *masterLink) start(ctx context.Context) {
+	ctx, ml.cancel = context.WithCancel(ctx)
+	go func() {
+		defer close(ml.done)
+		ml.replicate(ctx)
+	}()
+}
+
+// stop disconnects from the master and waits for the link to shut down.
+func (ml *masterLink) stop() {
+	ml.cancel()
+	ml.mu.Lock()
+	if ml.conn != nil {
+		ml.conn.Close()
+	}
+	ml.mu.Unlock()
+	<-ml.done
+}
+
+// replicate keeps the replica connected to the master. Whenever the link is
+// lost, the handshake is redone with an exponential backoff.
+func (ml *masterLink) replicate(ctx context.Context) {
+	logger := zerolog.Ctx(ctx)
+	backoff := minReconnectBackoff
+	for {
+		ml.state.SetState(replication.LinkConnecting)
+		conn, parser, err := ml.handshake(ctx)
+		if err != nil {
+			logger.Err(err).Msg("Failed to perform handshake with the master server")
+		} else {
+			ml.handleReplicationConnection(ctx, conn, parser)
+			if ml.state.State() == replication.LinkConnected {
+				// The link was healthy, so the master is worth retrying right away.
+				backoff = minReconnectBackoff
+			}
+			logger.Warn().Msg("Lost connection with the master server")
+		}
+		ml.state.SetState(replication.LinkConnect)
+
+		select {
+		case <-ctx.Done():
+			logger.Info().Str("replica_of", ml.address).Msg("Replication link stopped")
+			return
+		case <-time.After(backoff):
+		}
+		logger.Info().Dur("backoff", backoff).Msg("Reconnecting to the master server")
+		backoff = min(backoff*2, maxReconnectBackoff)
+	}
+}
+
+// attach makes conn the current master connection, so stop can interrupt it.
+// It returns false if the link was stopped in the meantime.
+func (ml *masterLink) attach(ctx context.Context, conn net.Conn) bool {
+	ml.mu.Lock()
+	defer ml.mu.Unlock()
+	if ctx.Err() != nil {
+		conn.Close()
+		return false
+	}
+	ml.conn = conn
+	return true
+}
+
+// handshake connects to the master and requests synchronization. The returned
+// parser reads the rest of the connection, as the master may have sent more
+// than the handshake replies already. The connection is attached before the
+// handshake, so stop interrupts it even if the master never answers.
+func (ml *masterLink) handshake(ctx context.Context) (net.Conn, *protocol.StreamParser, error) {
+	timeout := ml.config.ReplicationTimeout()
+	dialer := net.Dialer{Timeout: timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", ml.address)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to connect to server at %s: %w", ml.address, err)
+	}
+	if !ml.attach(ctx, conn) {
+		return nil, nil, fmt.Errorf("replication link stopped during handshake: %w", ctx.Err())
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+	reader := bufio.NewReader(conn)
+	if err := ml.negotiate(ctx, conn, reader); err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	// The commands are relayed to sub-replicas and counted in the offset
+	// exactly as the master sent them.
+	parser := protocol.NewStreamParser(reader, ml.config.MaxBulkLen())
+	parser.KeepRaw()
+	return conn, parser, nil
+}
+
+func (ml *masterLink) negotiate(ctx context.Context, conn net.Conn, reader *bufio.Reader) error {
+	logger := zerolog.Ctx(ctx)
+	logger.Info().Msg("Connected to master server, starting handshake")
+	logger.Info().Str("remote_addr", conn.RemoteAddr().String()).Msg("Connected to master server")
+
+	conn.Write(protocol.BulkArray([]string{"PING"}))
+	if err := expectSimpleResponse(reader, "PONG"); err != nil {
+		return fmt.Errorf("failed to perform ping with the master server: %w", err)
+	}
+
+	logger.Info().Msg("Ping successful, proceeding with REPLCONF handshake")
+	conn.Write(protocol.BulkArray([]string{"REPLCONF", "listening-port", strconv.Itoa(ml.config.ServerPort)}))
+	if err := expectSimpleResponse(reader, "OK"); err != nil {
+		return fmt.Errorf("failed to perform REPLCONF listening-port with the master server: %w", err)
+	}
+
+	logger.Info().Msg("REPLCONF listening-port successful, proceeding with REPLCONF capa psync2")
+	conn.Write(protocol.BulkArray([]string{"REPLCONF", "capa", "psync2"}))
+	if err := expectSimpleResponse(reader, "OK"); err != nil {
+		return fmt.Errorf("failed to perform REPLCONF capa psync2 with the master server: %w", err)
+	}
+
+	// The replica asks for the first byte missing in its own history, hoping
+	// for a partial resynchronization. A master that does not know the
+	// history answers with a full one.
+	replID := ml.replication.ReplID()
+	offset := strconv.FormatInt(ml.replication.Offset()+1, 10)
+	logger.Info().
+		Str("repl_id", replID).
+		Str("offset", offset).
+		Msg("REPLCONF capa psync2 successful, proceeding with PSYNC handshake")
+	conn.Write(protocol.BulkArray([]string{"PSYNC", replID, offset}))
+	ml.state.SetState(replication.LinkSync)
+
+	logger.Info().Msg("Handshake initiated successfully")
+	return nil
+}
+
+func expectSimpleResponse(reader *bufio.Reader, expected string) error {
+	resp, err := protocol.ParseResponse(reader)
+	if err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	if resp.Type != protocol.SimpleStringType {
+		return fmt.Errorf("unexpected response type: %s, expected: %s", resp.Type, protocol.SimpleStringType)
+	}
+	if resp.Value != expected {
+		return fmt.Errorf("unexpected response: %s", resp.Value)
+	}
+	return nil
+}
+
+func (ml *masterLink) handleReplicationConnection(ctx context.Context, conn net.Conn, parser *protocol.StreamParser) {
+	defer conn.Close()
+	logger := zerolog.Ctx(ctx).With().
+		Str("role", config.ReplicaRole).
+		Str("local_addr", conn.LocalAddr().String()).
+		Str("op", "handle_replication_conn").Logger()
+	logger.Info().Msg("Handling new connection for replication")
+
+	// A master that sends nothing for the replication timeout, not even the
+	// periodic PING, is considered lost, as the link may be partitioned.
+	timeout := ml.config.ReplicationTimeout()
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	// The PSYNC reply is not a part of the replication stream.
+	reply, err := parser.ReadResponse()
+	if err != nil {
+		logger.Err(err).Msg("Failed to read PSYNC reply")
+		return
+	}
+	if err := ml.handlePsyncReply(ctx, reply); err != nil {
+		logger.Err(err).Msg("Failed to handle PSYNC reply")
+		return
+	}
+	if reply.Name == protocol.FULLRESYNC {
+		conn.SetReadDeadline(time.Now().Add(timeout))
+		dump, err := parser.ReadRDB()
+		if err != nil {
+			logger.Err(err).Msg("Failed to read RDB dump from master server")
+			return
+		}
+		logger.Info().Int("size", len(dump)).Msg("Received RDB dump from master server")
+		if err := rdb.Load(dump, ml.storage); err != nil {
+			logger.Err(err).Msg("Failed to load RDB dump from master server")
+			return
+		}
+		// The commands logged so far no longer lead to the data set.
+		if ml.aof != nil {
+			if err := ml.aof.Create(rdb.Collect(ml.storage)); err != nil {
+				logger.Err(err).Msg("Failed to recreate the AOF file after full resync")
+			}
+		}
+		ml.state.SetState(replication.LinkConnected)
+		logger.Info().Msg("Loaded RDB dump from master server")
+	}
+
+	// The offset is reported both periodically and on GETACK, so the writes
+	// to the master are serialized.
+	var writeMu sync.Mutex
+	sendAck := func(offset int64) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		ack := []string{protocol.REPLCONF, "ACK", strconv.FormatInt(offset, 10)}
+		_, err := conn.Write(protocol.BulkArray(ack))
+		return err
+	}
+	stopAcks := make(chan struct{})
+	defer close(stopAcks)
+	go ml.sendAcks(logger, stopAcks, sendAck)
+
+	// Commands applied from the master are handled on behalf of a master
+	// client, so the replica does not reply to them.
+	master := client.NewMaster(conn)
+	for {
+		conn.SetReadDeadline(time.Now().Add(ml.config.ReplicationTimeout()))
+		command, size, err := parser.ReadCommand()
+		if err != nil {
+			if err == io.EOF {
+				logger.Err(err).Msg("Connection closed by master")
+				return
+			}
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				logger.Warn().Msg("Timeout waiting for data from master")
+				return
+			}
+			logger.Err(err).Msg("Error reading from connection")
+			return
+		}
+
+		logger := logger.With().
+			Str("command", command.Name).
+			Interface("args", command.Args).Logger()
+		logger.Info().Int("size", size).Msg("Parsed command")
+		if isGetAck(command) {
+			// The offset reported back excludes the GETACK command itself.
+			if err := sendAck(ml.replication.Offset()); err != nil {
+				logger.Err(err).Msg("Failed to send REPLCONF ACK to master")
+			}
+		} else {
+			result, err := ml.commandHandler.Handle(ctx, master, command)
+			if err != nil {
+				logger.Err(err).Msg("Failed to handle replicated command")
+			} else if result.CommandError != nil {
+				logger.Err(result.CommandError).Msg("Replicated command failed")
+			}
+		}
+		ml.replication.Feed(ctx, parser.Raw())
+	}
+}
+
+// sendAcks reports the replication offset to the master every ackInterval
+// until stop is closed, which lets the master track the lag of the replica.
+func (ml *masterLink) sendAcks(logger zerolog.Logger, stop <-chan struct{}, sendAck func(int64) error) {
+	ticker := time.NewTicker(ackInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := sendAck(ml.replication.Offset()); err != nil {
+				logger.Err(err).Msg("Failed to send REPLCONF ACK to master")
+				return
+			}
+		}
+	}
+}
+
+func (ml *masterLink) handlePsyncReply(ctx context.Context, command protocol.Command) error {
+	logger := zerolog.Ctx(ctx)
+	switch command.Name {
+	case protocol.FULLRESYNC:
+		if len(command.Args) != 2 {
+			return fmt.Errorf("FULLRESYNC reply requires exactly 2 arguments, got: %v", command.Args)
+		}
+		offset, err := strconv.ParseInt(command.Args[1], 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid offset in FULLRESYNC reply: %w", err)
+		}
+		ml.replication.Reset(command.Args[0], offset)
+		logger.Info().
+			Str("repl_id", command.Args[0]).
+			Int64("offset", offset).
+			Msg("Full resynchronization with master")
+	case protocol.CONTINUE:
+		if len(command.Args) > 0 && command.Args[0] != ml.replication.ReplID() {
+			// The master was promoted since, follow its new history.
+			ml.replication.ShiftReplID(command.Args[0])
+		}
+		ml.state.SetState(replication.LinkConnected)
+		logger.Info().
+			Str("repl_id", ml.replication.ReplID()).
+			Int64("offset", ml.replication.Offset()).
+			Msg("Partial resynchronization with master")
+	default:
+		return fmt.Errorf("unexpected PSYNC reply: %s", command.Name)
+	}
+	return nil
+}
+
+func isGetAck(command protocol.Command) bool {
+	return command.Name == protocol.REPLCONF &&
+		len(command.Args) > 0 &&
+		strings.EqualFold(command.Args[0], "GETACK")
+}
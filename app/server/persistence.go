@@ -0,0 +1,93 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/jorzel/myredis/app/client"
+	"github.com/jorzel/myredis/app/commands"
+	"github.com/jorzel/myredis/app/protocol"
+	"github.com/jorzel/myredis/app/rdb"
+	"github.com/rs/zerolog"
+)
+
+// savePointsInterval is how often the save points are checked.
+const savePointsInterval = time.Second
+
+// loadData restores the data set persisted before the restart. The AOF is
+// preferred when it is on, as it is the most up to date. If it was only just
+// turned on, it is created from the data set loaded from the RDB file.
+func (s *DefaultServer) loadData(ctx context.Context) error {
+	logger := zerolog.Ctx(ctx)
+	if s.aof != nil {
+		// Replayed commands are applied, but neither propagated nor logged again.
+		replay := commands.NewCommandHandler(s.config, commands.WithStorage(s.storage))
+		c := client.NewInternal()
+		found, err := s.aof.Load(ctx, func(command protocol.Command) error {
+			result, err := replay.Handle(ctx, c, command)
+			if err != nil {
+				return err
+			}
+			return result.CommandError
+		})
+		if err != nil {
+			return err
+		}
+		if found {
+			logger.Info().Str("path", s.aof.Path()).Msg("Loaded the data set from the AOF file")
+			return s.aof.Open(ctx)
+		}
+	}
+
+	if s.rdb != nil {
+		found, err := s.rdb.Load(s.storage)
+		if err != nil {
+			return err
+		}
+		if found {
+			logger.Info().Str("path", s.rdb.Path()).Msg("Loaded the data set from the RDB file")
+		}
+	}
+	if s.aof != nil {
+		if err := s.aof.Create(rdb.Collect(s.storage)); err != nil {
+			return err
+		}
+		return s.aof.Open(ctx)
+	}
+	return nil
+}
+
+// saveOnSavePoints starts a background save whenever one of the save points
+// is met, until ctx is done or a shutdown is prepared. Saves go through BGSAVE,
+// so the data set is collected the same way as when a client asks for it.
+func (s *DefaultServer) saveOnSavePoints(ctx context.Context) {
+	defer s.wg.Done()
+	logger := zerolog.Ctx(ctx)
+	ticker := time.NewTicker(savePointsInterval)
+	defer ticker.Stop()
+	c := client.NewInternal()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.done:
+			return
+		case now := <-ticker.C:
+			point, due := s.rdb.DueSavePoint(s.config.SaveParams(), now)
+			if !due {
+				continue
+			}
+			logger.Info().
+				Int("changes", point.Changes).
+				Int("seconds", point.Seconds).
+				Msg("Save point reached, saving in the background")
+			result, err := s.commandHandler.Handle(ctx, c, protocol.NewCommand("BGSAVE", nil))
+			if err == nil {
+				err = result.CommandError
+			}
+			if err != nil {
+				logger.Err(err).Msg("Failed to start the background save")
+			}
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"context"
+	"sync"
+
+	"github.com/jorzel/myredis/app/commands"
+	"github.com/jorzel/myredis/app/config"
+	"github.com/jorzel/myredis/app/persistence"
+	"github.com/jorzel/myredis/app/replication"
+	"github.com/jorzel/myredis/app/storage"
+	"github.com/rs/zerolog"
+)
+
+var _ commands.RoleSwitcher = (*roleController)(nil)
+
+// roleController switches the server between the master and replica roles at
+// runtime, attaching and detaching the link to the master accordingly:
+//
+//   - master -> replica: replicas are disconnected and a link is attached,
+//   - replica -> replica: the link is replaced by one to the new master,
+//   - replica -> master: the link is detached and a new history is started.
+type roleController struct {
+	mu          sync.Mutex
+	link        *replication.Link
+	replication *replication.Master
+	storage     storage.Storage
+	// aof is replaced on full resynchronization, nil if AOF persistence is off.
+	aof    *persistence.AOF
+	config *config.Config
+	// handler is set once the command handler, which depends on the
+	// controller itself, is created.
+	handler commands.CommandHandler
+	// running is the active link to the master, nil on a master.
+	running *masterLink
+}
+
+func newRoleController(
+	link *replication.Link, repl *replication.Master, store storage.Storage, aof *persistence.AOF, cfg *config.Config,
+) *roleController {
+	return &roleController{
+		link:        link,
+		replication: repl,
+		storage:     store,
+		aof:         aof,
+		config:      cfg,
+	}
+}
+
+// isReplica reports whether the server currently follows a master.
+func (rc *roleController) isReplica() bool {
+	return rc.link.Master() != nil
+}
+
+// role returns the name of the current role.
+func (rc *roleController) role() string {
+	if rc.isReplica() {
+		return config.ReplicaRole
+	}
+	return config.MasterRole
+}
+
+// ReplicaOf makes the server a replica of master, or promotes it to a master
+// if master is nil. The data set is kept on promotion, and a new replication
+// ID is started, so former replicas can still partially resynchronize.
+func (rc *roleController) ReplicaOf(ctx context.Context, master *config.Node) error {
+	logger := zerolog.Ctx(ctx)
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if rc.running != nil {
+		rc.running.stop()
+		rc.running = nil
+	}
+
+	// The config follows the role, so CONFIG REWRITE saves the current one.
+	rc.config.SetMaster(master)
+	if master == nil {
+		if rc.link.Master() == nil {
+			return nil
+		}
+		rc.link.SetMaster(nil)
+		rc.replication.ShiftReplID(replication.NewReplID())
+		logger.Info().
+			Str("repl_id", rc.replication.ReplID()).
+			Int64("offset", rc.replication.Offset()).
+			Msg("Promoted to master")
+		return nil
+	}
+
+	if rc.link.Master() == nil {
+		// Replicas have to follow the new history, so they resynchronize.
+		rc.replication.DisconnectReplicas()
+	}
+	rc.link.SetMaster(master)
+	rc.running = newMasterLink(master, rc.link, rc.replication, rc.storage, rc.aof, rc.handler, rc.config)
+	rc.running.start(context.WithoutCancel(ctx))
+	logger.Info().
+		Str("replica_of", rc.running.address).
+		Msg("Server configured as replica of")
+	return nil
+}
+
+// stop detaches the link to the master, if any, when the server stops.
+func (rc *roleController) stop() {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	if rc.running != nil {
+		rc.running.stop()
+		rc.running = nil
+	}
+}
@@ -2,8 +2,292 @@ package server
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/jorzel/myredis/app/client"
+	"github.com/jorzel/myredis/app/commands"
+	"github.com/jorzel/myredis/app/config"
+	"github.com/jorzel/myredis/app/persistence"
+	"github.com/jorzel/myredis/app/protocol"
+	"github.com/jorzel/myredis/app/replication"
+	"github.com/jorzel/myredis/app/storage"
+	"github.com/rs/zerolog"
 )
 
 type Server interface {
 	Start(ctx context.Context) error
 }
+
+var _ Server = (*DefaultServer)(nil)
+
+// pingInterval is how often a master pings its replicas, so they can tell an
+// idle link from a lost one.
+const pingInterval = 10 * time.Second
+
+// DefaultServer serves clients in either the master or the replica role.
+// The role is held by a role controller, which attaches and detaches the
+// link to a master at startup and whenever REPLICAOF changes it.
+type DefaultServer struct {
+	listener       net.Listener
+	commandHandler commands.CommandHandler
+	replication    *replication.Master
+	roles          *roleController
+	config         *config.Config
+	storage        storage.Storage
+	// rdb is the RDB file snapshots are saved to, nil if RDB persistence is off.
+	rdb *persistence.RDB
+	// aof logs write commands, nil if AOF persistence is off.
+	aof *persistence.AOF
+
+	gate *gate
+	// wg tracks the accept loop, the save points and connection goroutines.
+	wg        sync.WaitGroup
+	clientsMu sync.Mutex
+	clients   map[*client.Client]struct{}
+	stopped   bool
+	// done is closed once a shutdown is prepared.
+	done       chan struct{}
+	shutdownMu sync.Mutex
+}
+
+func NewServer(cfg *config.Config) (*DefaultServer, error) {
+	addr := fmt.Sprintf("0.0.0.0:%d", cfg.ServerPort)
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	store := storage.NewStorage()
+	var rdbFile *persistence.RDB
+	if cfg.DBFilename != "" {
+		rdbFile = persistence.NewRDB(cfg.RDBPath())
+	}
+	var aofFile *persistence.AOF
+	if cfg.AppendOnly {
+		aofFile = persistence.NewAOF(cfg.AOFPath(), cfg.AppendFsync)
+		aofFile.SetAutoRewrite(cfg.AutoAOFRewritePercentage, cfg.AutoAOFRewriteMinSize)
+	}
+	master := replication.NewMaster()
+	link := replication.NewLink(nil)
+	roles := newRoleController(link, master, store, aofFile, cfg)
+	s := &DefaultServer{
+		listener:    ln,
+		replication: master,
+		roles:       roles,
+		config:      cfg,
+		storage:     store,
+		rdb:         rdbFile,
+		aof:         aofFile,
+		gate:        newGate(),
+		clients:     map[*client.Client]struct{}{},
+		done:        make(chan struct{}),
+	}
+	roles.handler = commands.NewCommandHandler(
+		cfg,
+		commands.WithStorage(store),
+		commands.WithReplication(master),
+		commands.WithLink(link),
+		commands.WithRoleSwitcher(roles),
+		commands.WithShutdowner(s),
+		commands.WithRDB(rdbFile),
+		commands.WithAOF(aofFile),
+	)
+	s.commandHandler = roles.handler
+	return s, nil
+}
+
+// Addr returns the address the server accepts clients on.
+func (s *DefaultServer) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Start serves clients until ctx is done or SHUTDOWN is called, and then
+// stops the server gracefully.
+func (s *DefaultServer) Start(ctx context.Context) error {
+	logger := zerolog.Ctx(ctx)
+	if err := s.loadData(ctx); err != nil {
+		s.listener.Close()
+		return fmt.Errorf("failed to load data: %w", err)
+	}
+	if master := s.config.Master(); master != nil {
+		// Start a goroutine to handle the connection to the master server
+		// to be able to handle replication writes
+		if err := s.roles.ReplicaOf(ctx, master); err != nil {
+			return fmt.Errorf("failed to start replication: %w", err)
+		}
+	}
+	logger.Info().
+		Str("address", s.listener.Addr().String()).
+		Str("role", s.roles.role()).
+		Msg("Server listening on...")
+
+	// Commands blocked waiting, such as WAIT, give up once the server stops.
+	clientsCtx, cancelClients := context.WithCancel(ctx)
+	defer cancelClients()
+	s.wg.Add(1)
+	go s.accept(clientsCtx)
+	s.wg.Add(1)
+	go s.pingReplicas(ctx)
+	// Save points can be set at runtime, so they are checked even if there
+	// are none yet.
+	if s.rdb != nil {
+		s.wg.Add(1)
+		go s.saveOnSavePoints(ctx)
+	}
+	select {
+	case <-ctx.Done():
+		// The shutdown still has to wait for replicas after ctx is done.
+		if err := s.Shutdown(context.WithoutCancel(ctx), commands.ShutdownOptions{}); err != nil {
+			logger.Err(err).Msg("Failed to prepare shutdown, stopping anyway")
+		}
+	case <-s.done:
+	}
+	s.stop(ctx, cancelClients)
+	return nil
+}
+
+func (s *DefaultServer) accept(ctx context.Context) {
+	defer s.wg.Done()
+	logger := zerolog.Ctx(ctx)
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			logger.Err(err).Msg("Failed to accept connection")
+			continue
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handleConnection(ctx, conn)
+		}()
+	}
+}
+
+// pingReplicas sends PING to the replicas every pingInterval while the server
+// is a master, until ctx is done or a shutdown is prepared.
+func (s *DefaultServer) pingReplicas(ctx context.Context) {
+	defer s.wg.Done()
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.done:
+			return
+		case <-ticker.C:
+			// A replica relays the pings of its own master instead.
+			if !s.roles.isReplica() && len(s.replication.Replicas()) > 0 {
+				s.replication.Propagate(ctx, protocol.NewCommand(protocol.PING, nil))
+			}
+		}
+	}
+}
+
+// track registers c until untrack is called. It returns false if the server
+// is already stopped.
+func (s *DefaultServer) track(c *client.Client) bool {
+	s.clientsMu.Lock()
+	defer s.clientsMu.Unlock()
+	if s.stopped {
+		return false
+	}
+	s.clients[c] = struct{}{}
+	return true
+}
+
+func (s *DefaultServer) untrack(c *client.Client) {
+	s.clientsMu.Lock()
+	defer s.clientsMu.Unlock()
+	delete(s.clients, c)
+}
+
+func (s *DefaultServer) handleConnection(ctx context.Context, netConn net.Conn) {
+	// The client keeps the connection state, such as the protocol version
+	// negotiated with HELLO.
+	conn := client.New(netConn)
+	defer conn.Close()
+	if !s.track(conn) {
+		return
+	}
+	defer s.untrack(conn)
+	defer s.replication.RemoveReplica(conn)
+	// Replies left in the buffer are sent before the connection is closed.
+	defer conn.Flush()
+	logger := zerolog.Ctx(ctx).With().
+		Str("remote_addr", conn.RemoteAddr().String()).
+		Logger()
+	logger.Info().Msg("Handling new connection")
+
+	parser := protocol.NewStreamParser(conn, s.config.MaxBulkLen())
+
+	for {
+		command, _, err := parser.ReadCommand()
+		if err != nil {
+			if err == io.EOF {
+				logger.Info().Msg("Connection closed by client")
+				return
+			}
+			if errors.Is(err, protocol.ErrProtocol) {
+				// The stream cannot be resynchronized after a malformed frame.
+				logger.Err(err).Msg("Failed to parse received data, closing connection")
+				conn.WriteReply(protocol.NewError(err.Error()))
+				conn.Flush()
+				return
+			}
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				logger.Info().Msg("Connection closed on shutdown")
+				return
+			}
+			logger.Err(err).Msg("Error reading from connection")
+			return
+		}
+
+		logger := logger.With().
+			Str("role", s.roles.role()).
+			Str("command", command.Name).
+			Interface("args", command.Args).Logger()
+		logger.Info().Msg("Parsed command")
+
+		write := commands.IsWrite(command)
+		if !s.gate.enter(write) {
+			logger.Info().Msg("Command not handled, server is shutting down")
+			return
+		}
+		if write && s.roles.isReplica() && s.config.IsReplicaReadOnly() {
+			logger.Warn().Msg("Write command rejected on read only replica")
+			if err := conn.WriteReply(protocol.ErrReadOnly); err != nil {
+				logger.Err(err).Msg("Failed to write response")
+			}
+		} else {
+			result, err := s.commandHandler.Handle(ctx, conn, command)
+			if err != nil {
+				logger.Err(err).Msg("Failed to handle command")
+			} else if result.CommandError != nil {
+				logger.Err(result.CommandError).Msg("Command error occurred, sending error response")
+			}
+		}
+
+		// Replies to pipelined commands are sent together, once all the
+		// commands received so far are handled.
+		var flushErr error
+		if parser.Buffered() == 0 {
+			flushErr = conn.Flush()
+		}
+		// The command is done once its reply is sent, so a shutdown does not
+		// close the connection before that.
+		s.gate.leave(write)
+		if flushErr != nil {
+			logger.Err(flushErr).Msg("Failed to write response")
+			return
+		}
+	}
+}
@@ -0,0 +1,498 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jorzel/myredis/app/commands"
+	"github.com/jorzel/myredis/app/config"
+	"github.com/jorzel/myredis/app/protocol"
+	"github.com/jorzel/myredis/app/rdb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startServer starts a server on a random port and returns its address.
+func startServer(t *testing.T, cfg *config.Config) *net.TCPAddr {
+	t.Helper()
+	srv, _ := runServer(t, cfg)
+	return srv.Addr().(*net.TCPAddr)
+}
+
+// runServer starts a server on a random port, keeping its files in a
+// temporary directory unless cfg sets one. Start returns its error on the
+// channel. The server is shut down at the end of the test, if it still runs.
+func runServer(t *testing.T, cfg *config.Config) (*DefaultServer, <-chan error) {
+	t.Helper()
+	if cfg.Dir == "" {
+		cfg.Dir = t.TempDir()
+	}
+	srv, err := NewServer(cfg)
+	require.NoError(t, err)
+	stopped := make(chan error, 1)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		stopped <- srv.Start(context.Background())
+	}()
+	t.Cleanup(func() {
+		srv.Shutdown(context.Background(), commands.ShutdownOptions{Now: true, NoSave: true})
+		<-done
+	})
+	return srv, stopped
+}
+
+// testClient sends a command and reads a single line or bulk string reply.
+type testClient struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func dial(t *testing.T, addr *net.TCPAddr) *testClient {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr.String())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return &testClient{conn: conn, reader: bufio.NewReader(conn)}
+}
+
+func (c *testClient) do(t *testing.T, args ...string) string {
+	t.Helper()
+	_, err := c.conn.Write(protocol.BulkArray(args))
+	require.NoError(t, err)
+	return c.read(t)
+}
+
+// read reads a single line or bulk string reply.
+func (c *testClient) read(t *testing.T) string {
+	t.Helper()
+	line, err := c.reader.ReadString('\n')
+	require.NoError(t, err)
+	if line[0] != '$' || line == "$-1\r\n" {
+		return line
+	}
+	length, err := strconv.Atoi(line[1 : len(line)-2])
+	require.NoError(t, err)
+	payload := make([]byte, length+2)
+	_, err = io.ReadFull(c.reader, payload)
+	require.NoError(t, err)
+	return string(payload[:length])
+}
+
+// configGet returns the value of a parameter from CONFIG GET.
+func configGet(t *testing.T, c *testClient, name string) string {
+	t.Helper()
+	require.Equal(t, "*2\r\n", c.do(t, "CONFIG", "GET", name))
+	require.Equal(t, name, c.read(t))
+	return c.read(t)
+}
+
+// replicationInfo returns a field of the replication section of INFO.
+func replicationInfo(t *testing.T, c *testClient, field string) string {
+	t.Helper()
+	for _, line := range strings.Split(c.do(t, "INFO", "replication"), "\r\n") {
+		if value, ok := strings.CutPrefix(line, field+":"); ok {
+			return value
+		}
+	}
+	return ""
+}
+
+func TestServerReplicatesWritesToReplica(t *testing.T) {
+	masterAddr := startServer(t, &config.Config{})
+	master := dial(t, masterAddr)
+	require.Equal(t, "+OK\r\n", master.do(t, "SET", "before", "1"))
+
+	replicaAddr := startServer(t, &config.Config{
+		ReplicaOf:       &config.Node{Host: "127.0.0.1", Port: masterAddr.Port},
+		ReplicaReadOnly: true,
+	})
+	replica := dial(t, replicaAddr)
+
+	require.Equal(t, "+OK\r\n", master.do(t, "SET", "after", "2"))
+	require.Eventually(t, func() bool {
+		return replica.do(t, "GET", "after") == "2"
+	}, 5*time.Second, 20*time.Millisecond)
+	assert.Equal(t, "1", replica.do(t, "GET", "before"), "snapshot taken on full resync")
+	assert.Equal(t, "-READONLY You can't write against a read only replica.\r\n", replica.do(t, "SET", "x", "1"))
+}
+
+func TestServerReplicaReconnectsToMaster(t *testing.T) {
+	srv, _ := runServer(t, &config.Config{})
+	masterAddr := srv.Addr().(*net.TCPAddr)
+	master := dial(t, masterAddr)
+	replicaAddr := startServer(t, &config.Config{
+		ReplicaOf:       &config.Node{Host: "127.0.0.1", Port: masterAddr.Port},
+		ReplicaReadOnly: true,
+	})
+	replica := dial(t, replicaAddr)
+
+	require.Equal(t, "+OK\r\n", master.do(t, "SET", "key", "0"))
+	require.Eventually(t, func() bool {
+		return replica.do(t, "GET", "key") == "0"
+	}, 5*time.Second, 20*time.Millisecond)
+
+	// The link is lost twice, so the backoff is reset after a healthy link.
+	for i := 1; i <= 2; i++ {
+		value := strconv.Itoa(i)
+		srv.replication.DisconnectReplicas()
+		require.Equal(t, "+OK\r\n", master.do(t, "SET", "key", value))
+		assert.NotEqual(t, "$-1\r\n", replica.do(t, "GET", "key"), "reads served while disconnected")
+
+		require.Eventually(t, func() bool {
+			return replica.do(t, "GET", "key") == value
+		}, 5*time.Second, 20*time.Millisecond)
+		require.Eventually(t, func() bool {
+			return strings.Contains(replica.do(t, "INFO", "replication"), "master_link_status:up")
+		}, 5*time.Second, 20*time.Millisecond)
+	}
+}
+
+func TestServerPromotesWhileHandshakeIsPending(t *testing.T) {
+	// The master accepts the connection but never answers the handshake.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			accepted <- conn
+		}
+	}()
+	c := dial(t, startServer(t, &config.Config{}))
+	port := strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)
+	require.Equal(t, "+OK\r\n", c.do(t, "REPLICAOF", "127.0.0.1", port))
+	select {
+	case conn := <-accepted:
+		defer conn.Close()
+	case <-time.After(5 * time.Second):
+		t.Fatal("replica did not connect to the master")
+	}
+
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	assert.Equal(t, "+OK\r\n", c.do(t, "REPLICAOF", "NO", "ONE"))
+	assert.Contains(t, c.do(t, "INFO", "replication"), "role:master")
+}
+
+func TestServerReplicaReconnectsToSilentMaster(t *testing.T) {
+	// The master completes a full resynchronization, then sends nothing.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	accepted := make(chan struct{}, 4)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- struct{}{}
+			go serveSilentMaster(conn)
+		}
+	}()
+	startServer(t, &config.Config{
+		ReplicaOf:   &config.Node{Host: "127.0.0.1", Port: listener.Addr().(*net.TCPAddr).Port},
+		ReplTimeout: 1,
+	})
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-accepted:
+		case <-time.After(5 * time.Second):
+			t.Fatal("replica did not reconnect to a silent master")
+		}
+	}
+}
+
+// serveSilentMaster answers the replication handshake with a full
+// resynchronization of an empty data set and then only reads.
+func serveSilentMaster(conn net.Conn) {
+	defer conn.Close()
+	parser := protocol.NewStreamParser(conn, 0)
+	for {
+		command, _, err := parser.ReadCommand()
+		if err != nil {
+			return
+		}
+		switch strings.ToUpper(command.Name) {
+		case "PING":
+			conn.Write([]byte("+PONG\r\n"))
+		case "REPLCONF":
+			if !strings.EqualFold(command.Args[0], "ACK") {
+				conn.Write([]byte("+OK\r\n"))
+			}
+		case "PSYNC":
+			conn.Write([]byte("+FULLRESYNC " + strings.Repeat("a", 40) + " 0\r\n"))
+			conn.Write(protocol.FileContent(rdb.Encode(nil)))
+		}
+	}
+}
+
+func TestServerReplicaReadOnly(t *testing.T) {
+	tests := []struct {
+		name     string
+		readOnly bool
+		setReply string
+	}{
+		{
+			name:     "read only",
+			readOnly: true,
+			setReply: "-READONLY You can't write against a read only replica.\r\n",
+		},
+		{
+			name:     "writable",
+			readOnly: false,
+			setReply: "+OK\r\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			masterAddr := startServer(t, &config.Config{})
+			master := dial(t, masterAddr)
+			replicaAddr := startServer(t, &config.Config{
+				ReplicaOf:       &config.Node{Host: "127.0.0.1", Port: masterAddr.Port},
+				ReplicaReadOnly: tt.readOnly,
+			})
+			replica := dial(t, replicaAddr)
+
+			require.Equal(t, "+OK\r\n", master.do(t, "SET", "replicated", "1"))
+			require.Eventually(t, func() bool {
+				return replica.do(t, "GET", "replicated") == "1"
+			}, 5*time.Second, 20*time.Millisecond)
+
+			assert.Equal(t, tt.setReply, replica.do(t, "SET", "local", "1"))
+			if tt.readOnly {
+				assert.Equal(t, "$-1\r\n", replica.do(t, "GET", "local"))
+				assert.Equal(t, "-READONLY You can't write against a read only replica.\r\n", replica.do(t, "DEL", "replicated"))
+			}
+
+			// Writes coming from the master are applied either way.
+			require.Equal(t, ":1\r\n", master.do(t, "DEL", "replicated"))
+			require.Equal(t, "+OK\r\n", master.do(t, "SET", "after", "2"))
+			require.Eventually(t, func() bool {
+				return replica.do(t, "GET", "after") == "2"
+			}, 5*time.Second, 20*time.Millisecond)
+			assert.Equal(t, "$-1\r\n", replica.do(t, "GET", "replicated"))
+		})
+	}
+}
+
+func TestServerReplicaAcknowledgesPeriodically(t *testing.T) {
+	masterAddr := startServer(t, &config.Config{})
+	master := dial(t, masterAddr)
+	replicaAddr := startServer(t, &config.Config{
+		ReplicaOf:       &config.Node{Host: "127.0.0.1", Port: masterAddr.Port},
+		ReplicaReadOnly: true,
+	})
+	replica := dial(t, replicaAddr)
+
+	require.Equal(t, "+OK\r\n", master.do(t, "SET", "key", "1"))
+	require.Eventually(t, func() bool {
+		return replica.do(t, "GET", "key") == "1"
+	}, 5*time.Second, 20*time.Millisecond)
+
+	// Nothing is written and the master never asks for an acknowledgement,
+	// yet the replica keeps reporting its offset.
+	time.Sleep(2500 * time.Millisecond)
+	offset := replicationInfo(t, master, "master_repl_offset")
+	slave := replicationInfo(t, master, "slave0")
+	require.NotEmpty(t, slave)
+	assert.Contains(t, slave, ",offset="+offset+",")
+	assert.Regexp(t, `,lag=[01]$`, slave)
+}
+
+func TestServerSwitchesRoles(t *testing.T) {
+	masterAddr := startServer(t, &config.Config{})
+	serverAddr := startServer(t, &config.Config{ReplicaReadOnly: true})
+	master := dial(t, masterAddr)
+	server := dial(t, serverAddr)
+
+	require.Equal(t, "+OK\r\n", server.do(t, "SET", "local", "1"))
+	require.Equal(t, "+OK\r\n", server.do(t, "REPLICAOF", "127.0.0.1", strconv.Itoa(masterAddr.Port)))
+	assert.Equal(t, "127.0.0.1 "+strconv.Itoa(masterAddr.Port), configGet(t, server, "replicaof"))
+	require.Equal(t, "+OK\r\n", master.do(t, "SET", "replicated", "1"))
+	require.Eventually(t, func() bool {
+		return server.do(t, "GET", "replicated") == "1"
+	}, 5*time.Second, 20*time.Millisecond)
+	assert.Equal(t, "$-1\r\n", server.do(t, "GET", "local"), "data set replaced by the master's one")
+	assert.Equal(t, "-READONLY You can't write against a read only replica.\r\n", server.do(t, "SET", "x", "1"))
+
+	require.Equal(t, "+OK\r\n", server.do(t, "REPLICAOF", "NO", "ONE"))
+	assert.Equal(t, "", configGet(t, server, "replicaof"), "promotion recorded in the config")
+	assert.Equal(t, "+OK\r\n", server.do(t, "SET", "x", "1"))
+	assert.Equal(t, "1", server.do(t, "GET", "replicated"), "data set kept on promotion")
+}
+
+func TestServerShutdownCommand(t *testing.T) {
+	srv, stopped := runServer(t, &config.Config{})
+	c := dial(t, srv.Addr().(*net.TCPAddr))
+	idle := dial(t, srv.Addr().(*net.TCPAddr))
+	require.Equal(t, "+OK\r\n", c.do(t, "SET", "key", "value"))
+
+	_, err := c.conn.Write(protocol.BulkArray([]string{"SHUTDOWN", "NOSAVE"}))
+	require.NoError(t, err)
+
+	select {
+	case err := <-stopped:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not stop")
+	}
+	_, err = c.reader.ReadString('\n')
+	assert.ErrorIs(t, err, io.EOF, "connection closed without a reply")
+	_, err = idle.reader.ReadString('\n')
+	assert.ErrorIs(t, err, io.EOF, "idle connection closed")
+	_, err = net.Dial("tcp", srv.Addr().String())
+	assert.Error(t, err, "listener closed")
+}
+
+func TestServerShutdownWithPendingWait(t *testing.T) {
+	srv, stopped := runServer(t, &config.Config{})
+	waiting := dial(t, srv.Addr().(*net.TCPAddr))
+	c := dial(t, srv.Addr().(*net.TCPAddr))
+	// Without replicas, WAIT without a timeout would block forever.
+	_, err := waiting.conn.Write(protocol.BulkArray([]string{"WAIT", "1", "0"}))
+	require.NoError(t, err)
+	time.Sleep(100 * time.Millisecond)
+
+	_, err = c.conn.Write(protocol.BulkArray([]string{"SHUTDOWN", "NOSAVE"}))
+	require.NoError(t, err)
+
+	select {
+	case err := <-stopped:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not stop")
+	}
+	reply, err := waiting.reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, ":0\r\n", reply, "Expected WAIT to reply before the connection is closed")
+}
+
+func TestServerShutdownReplicaWithSubReplica(t *testing.T) {
+	masterAddr := startServer(t, &config.Config{})
+	master := dial(t, masterAddr)
+	srv, stopped := runServer(t, &config.Config{
+		ReplicaOf:       &config.Node{Host: "127.0.0.1", Port: masterAddr.Port},
+		ReplicaReadOnly: true,
+	})
+	replicaAddr := srv.Addr().(*net.TCPAddr)
+	replica := dial(t, replicaAddr)
+	subReplica := dial(t, startServer(t, &config.Config{
+		ReplicaOf:       &config.Node{Host: "127.0.0.1", Port: replicaAddr.Port},
+		ReplicaReadOnly: true,
+	}))
+
+	require.Equal(t, "+OK\r\n", master.do(t, "SET", "key", "1"))
+	require.Eventually(t, func() bool {
+		return subReplica.do(t, "GET", "key") == "1"
+	}, 5*time.Second, 20*time.Millisecond)
+
+	_, err := replica.conn.Write(protocol.BulkArray([]string{"SHUTDOWN", "NOSAVE"}))
+	require.NoError(t, err)
+	select {
+	case err := <-stopped:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not stop")
+	}
+	// A replica only relays the stream of its master, it does not ask
+	// sub-replicas for acknowledgements of its own.
+	assert.Equal(t,
+		replicationInfo(t, master, "master_repl_offset"),
+		replicationInfo(t, subReplica, "slave_repl_offset"))
+}
+
+func TestServerStopsWhenContextIsDone(t *testing.T) {
+	srv, err := NewServer(&config.Config{})
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error, 1)
+	go func() { stopped <- srv.Start(ctx) }()
+	c := dial(t, srv.Addr().(*net.TCPAddr))
+	require.Equal(t, "+PONG\r\n", c.do(t, "PING"))
+
+	cancel()
+
+	select {
+	case err := <-stopped:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not stop")
+	}
+	_, err = c.reader.ReadString('\n')
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestServerLoadsSnapshotSavedOnShutdown(t *testing.T) {
+	cfg := &config.Config{
+		Dir:        t.TempDir(),
+		DBFilename: "dump.rdb",
+		SavePoints: []config.SavePoint{{Seconds: 3600, Changes: 1}},
+	}
+	srv, stopped := runServer(t, cfg)
+	c := dial(t, srv.Addr().(*net.TCPAddr))
+	require.Equal(t, "+OK\r\n", c.do(t, "SET", "key", "value"))
+
+	_, err := c.conn.Write(protocol.BulkArray([]string{"SHUTDOWN"}))
+	require.NoError(t, err)
+	select {
+	case err := <-stopped:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("server did not stop")
+	}
+
+	restarted := dial(t, startServer(t, cfg))
+	assert.Equal(t, "value", restarted.do(t, "GET", "key"))
+}
+
+func TestServerSavesOnSavePoints(t *testing.T) {
+	cfg := &config.Config{
+		Dir:        t.TempDir(),
+		DBFilename: "dump.rdb",
+		SavePoints: []config.SavePoint{{Seconds: 1, Changes: 2}},
+	}
+	c := dial(t, startServer(t, cfg))
+	require.Equal(t, "+OK\r\n", c.do(t, "SET", "key", "value"))
+	require.Equal(t, "+OK\r\n", c.do(t, "SET", "other", "value"))
+
+	require.Eventually(t, func() bool {
+		return strings.Contains(c.do(t, "INFO", "persistence"), "rdb_changes_since_last_save:0\r\n")
+	}, 5*time.Second, 10*time.Millisecond, "Expected a snapshot once the save point is met")
+	_, err := os.Stat(cfg.RDBPath())
+	assert.NoError(t, err)
+}
+
+func TestServerReplaysAOFOnRestart(t *testing.T) {
+	cfg := &config.Config{
+		Dir:            t.TempDir(),
+		AppendOnly:     true,
+		AppendFilename: "appendonly.aof",
+		AppendFsync:    config.AppendFsyncAlways,
+	}
+	srv, err := NewServer(cfg)
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error, 1)
+	go func() { stopped <- srv.Start(ctx) }()
+	c := dial(t, srv.Addr().(*net.TCPAddr))
+	require.Equal(t, "+OK\r\n", c.do(t, "SET", "key", "value"))
+	require.Equal(t, "+OK\r\n", c.do(t, "SET", "deleted", "value"))
+	require.Equal(t, ":1\r\n", c.do(t, "DEL", "deleted"))
+	cancel()
+	require.NoError(t, <-stopped)
+
+	restarted := dial(t, startServer(t, cfg))
+	assert.Equal(t, "value", restarted.do(t, "GET", "key"))
+	assert.Equal(t, "$-1\r\n", restarted.do(t, "GET", "deleted"))
+}
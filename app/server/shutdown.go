@@ -0,0 +1,112 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/jorzel/myredis/app/commands"
+	"github.com/jorzel/myredis/app/rdb"
+	"github.com/rs/zerolog"
+)
+
+var _ commands.Shutdowner = (*DefaultServer)(nil)
+
+// shutdownTimeout bounds how long a shutdown waits for lagging replicas.
+const shutdownTimeout = 10 * time.Second
+
+// Shutdown prepares the server to stop and makes Start return. Writes are
+// paused from then on, so lagging replicas can catch up with the master
+// unless opts.Now is set, and the final snapshot contains every acknowledged
+// write. Commands already running are drained by Start.
+//
+// The snapshot is saved if save points are configured, unless opts.NoSave
+// is set.
+// If it fails, the server keeps running unless opts.Force is set.
+func (s *DefaultServer) Shutdown(ctx context.Context, opts commands.ShutdownOptions) error {
+	logger := zerolog.Ctx(ctx)
+	s.shutdownMu.Lock()
+	defer s.shutdownMu.Unlock()
+	select {
+	case <-s.done:
+		return nil
+	default:
+	}
+
+	logger.Info().Interface("options", opts).Msg("Preparing to shut down")
+	s.gate.pauseWrites()
+	// A replica relays its master's stream as is, so it can't ask its own
+	// replicas for acknowledgements.
+	if !opts.Now && !s.roles.isReplica() {
+		s.waitForReplicas(ctx)
+	}
+	if opts.Save || (s.rdb != nil && len(s.config.SaveParams()) > 0 && !opts.NoSave) {
+		if err := s.save(ctx); err != nil && !opts.Force {
+			s.gate.resumeWrites()
+			return err
+		}
+	}
+	close(s.done)
+	return nil
+}
+
+// waitForReplicas waits until every replica acknowledged the current offset,
+// at most for shutdownTimeout.
+func (s *DefaultServer) waitForReplicas(ctx context.Context) {
+	logger := zerolog.Ctx(ctx)
+	replicas := len(s.replication.Replicas())
+	if replicas == 0 {
+		return
+	}
+	acked := s.replication.WaitForAcks(ctx, replicas, shutdownTimeout)
+	if acked < replicas {
+		logger.Warn().
+			Int("replicas", replicas).
+			Int("acked", acked).
+			Msg("Lagging replicas did not catch up before shutdown")
+	}
+}
+
+// save saves the final snapshot to the RDB file.
+func (s *DefaultServer) save(ctx context.Context) error {
+	logger := zerolog.Ctx(ctx)
+	if s.rdb == nil {
+		err := errors.New("no RDB file is configured")
+		logger.Err(err).Msg("Failed to save the final snapshot")
+		return err
+	}
+	if err := s.rdb.Save(rdb.Collect(s.storage)); err != nil {
+		logger.Err(err).Msg("Failed to save the final snapshot")
+		return err
+	}
+	logger.Info().Str("path", s.rdb.Path()).Msg("Saved the final snapshot")
+	return nil
+}
+
+// stop drains the server: it stops accepting connections, waits for the
+// commands already running and closes every connection once its replies
+// are sent. cancelClients cancels the context of the connections, so
+// commands blocked without a timeout return instead of holding the drain.
+func (s *DefaultServer) stop(ctx context.Context, cancelClients context.CancelFunc) {
+	logger := zerolog.Ctx(ctx)
+	s.listener.Close()
+	cancelClients()
+	s.gate.close()
+	s.roles.stop()
+	s.replication.DisconnectReplicas()
+	if s.aof != nil {
+		if err := s.aof.Close(); err != nil {
+			logger.Err(err).Msg("Failed to close the AOF file")
+		}
+	}
+
+	s.clientsMu.Lock()
+	s.stopped = true
+	for c := range s.clients {
+		// Clients blocked on reading a command give up right away.
+		c.SetReadDeadline(time.Now())
+	}
+	s.clientsMu.Unlock()
+	s.wg.Wait()
+	logger.Info().Msg("Server stopped")
+}
@@ -17,6 +17,9 @@ type Storage interface {
 	Get(key string) (*KVRecord, error)
 	Set(key string, value *KVRecord) error
 	Del(key string) error
+	Flush() error
+	// Range calls fn for every stored key until fn returns false.
+	Range(fn func(key string, record *KVRecord) bool)
 }
 
 type DefaultStorage struct {
@@ -53,3 +56,18 @@ func (s *DefaultStorage) Del(key string) error {
 	s.db.Delete(key)
 	return nil
 }
+
+func (s *DefaultStorage) Flush() error {
+	s.db.Clear()
+	return nil
+}
+
+func (s *DefaultStorage) Range(fn func(key string, record *KVRecord) bool) {
+	s.db.Range(func(key, value any) bool {
+		record, ok := value.(*KVRecord)
+		if !ok {
+			return true
+		}
+		return fn(key.(string), record)
+	})
+}